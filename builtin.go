@@ -1,16 +1,95 @@
 package fxparse
 
-import "fxsym"
+import (
+	"fmt"
+	"fxsym"
+)
+
+// BuiltinFunc runs a builtin. args hold the values of the arguments,
+// one per parameter and of its type.
+type BuiltinFunc func(ctx *Ctx, args []Value) error
+
+// Param is a parameter of a builtin.
+type Param struct {
+	Name string
+	Type int
+}
 
 type Builtin struct {
-	name string
-	kind int
-	args []string
+	name   string
+	kind   int
+	params []Param
+	fn     BuiltinFunc
 }
 
 var (
 	builtins = map[string]Builtin{
-		"circle": {"circle", fxsym.SFunc, []string{"x", "y", "r", "color"}},
-		"rect":   {"rect", fxsym.SFunc, []string{"x", "y", "angle", "color"}},
+		"circle": {"circle", fxsym.SFunc, pointParams("r", "color"), circle},
+		"rect":   {"rect", fxsym.SFunc, pointParams("angle", "color"), rect},
 	}
 )
+
+// pointParams returns the parameters of a builtin drawing at a point:
+// the point, followed by ints with the given names.
+func pointParams(names ...string) []Param {
+	params := []Param{{"at", TCoord}}
+	for _, name := range names {
+		params = append(params, Param{name, TInt})
+	}
+	return params
+}
+
+// builtinArgs groups the arguments of a call to a builtin, of types
+// tps, by the parameter they are for, and returns how many arguments
+// each group has. A Coord parameter takes either a Coord argument or,
+// if there are more arguments than parameters left, two arguments,
+// which must be ints. Any other parameter takes one argument.
+func builtinArgs(params []*fxsym.Sym, tps []int) (groups []int) {
+	for n := 0; n < len(tps); {
+		k := 1
+		if i := len(groups); i < len(params) && params[i].Type() == TCoord &&
+			tps[n] != TCoord && len(tps)-n > len(params)-i {
+			k = 2
+		}
+		groups = append(groups, k)
+		n += k
+	}
+	return groups
+}
+
+func circle(ctx *Ctx, args []Value) error {
+	r := args[1].Int()
+	if r < 0 {
+		return fmt.Errorf("negative radius %d", r)
+	}
+	return ctx.r.Circle(args[0].Coord(), r, args[2].Int())
+}
+
+func rect(ctx *Ctx, args []Value) error {
+	return ctx.r.Rect(args[0].Coord(), args[1].Int(), args[2].Int())
+}
+
+// RegisterBuiltin adds to the language parsed by p a builtin with
+// the given parameters, run by calling fn. As for circle and rect, a
+// Coord parameter also takes two int arguments. Parameters must have
+// type TInt, TBool or TCoord. It must be called before parsing.
+func (p *Parser) RegisterBuiltin(name string, params []Param, fn BuiltinFunc) error {
+	if fn == nil {
+		return fmt.Errorf("builtin %s: nil func", name)
+	}
+	if keywords[name] {
+		return fmt.Errorf("builtin %s: %s is reserved", name, name)
+	}
+	for _, param := range params {
+		if keywords[param.Name] {
+			return fmt.Errorf("builtin %s: %s is reserved", name, param.Name)
+		}
+		if param.Type < TInt || param.Type > TCoord {
+			return fmt.Errorf("builtin %s: bad type %d of parameter %s", name, param.Type, param.Name)
+		}
+	}
+	p.stkEnv.PopEnv()
+	defer p.stkEnv.PushEnv()
+
+	return defBuiltin(&p.stkEnv, Builtin{name, fxsym.SFunc, params, fn})
+}
@@ -0,0 +1,126 @@
+// Fxfmt formats fx programs.
+//
+// Usage:
+//
+//	fxfmt [-w] [file ...]
+//
+// Without files, it formats the standard input. The formatted source
+// is written to the standard output, unless -w is given, in which case
+// each file is rewritten in place if its formatting changed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"fxparse"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var write = flag.Bool("w", false, "write result to (source) file instead of stdout")
+
+func format(name string, src []byte) ([]byte, error) {
+	p, err := fxparse.NewSourceParser(name, src)
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := fxparse.Fprint(&out, prog); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func formatFile(name string) error {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	out, err := format(name, src)
+	if err != nil {
+		return err
+	}
+
+	if !*write {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	if bytes.Equal(src, out) {
+		return nil
+	}
+
+	return writeFile(name, out)
+}
+
+// writeFile replaces the contents of name with data, keeping its
+// permissions. The data is written to a temporary file in the same
+// directory first, so that name is never left half written.
+func writeFile(name string, data []byte) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: fxfmt [-w] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	status := 0
+	if flag.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			var out []byte
+			if out, err = format("<stdin>", src); err == nil {
+				_, err = os.Stdout.Write(out)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+
+	for _, name := range flag.Args() {
+		if err := formatFile(name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+
+	os.Exit(status)
+}
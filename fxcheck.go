@@ -0,0 +1,245 @@
+package fxparse
+
+import (
+	"fmt"
+	"fxlex"
+)
+
+type opType struct {
+	left   int // TUndef for unary operators
+	right  int
+	result int
+}
+
+// opTypes holds the types of the operands each operator accepts and
+// the type of its result.
+var opTypes = map[int][]opType{
+	'|':          {{TBool, TBool, TBool}, {TInt, TInt, TInt}},
+	'&':          {{TBool, TBool, TBool}, {TInt, TInt, TInt}},
+	'^':          {{TBool, TBool, TBool}, {TInt, TInt, TInt}},
+	'!':          {{TUndef, TBool, TBool}},
+	'~':          {{TUndef, TInt, TInt}},
+	OpEq:         {{TInt, TInt, TBool}, {TBool, TBool, TBool}, {TCoord, TCoord, TBool}},
+	OpNeq:        {{TInt, TInt, TBool}, {TBool, TBool, TBool}, {TCoord, TCoord, TBool}},
+	'<':          {{TInt, TInt, TBool}},
+	'>':          {{TInt, TInt, TBool}},
+	fxlex.TokGTE: {{TInt, TInt, TBool}},
+	fxlex.TokLTE: {{TInt, TInt, TBool}},
+	OpShl:        {{TInt, TInt, TInt}},
+	OpShr:        {{TInt, TInt, TInt}},
+	'+': {
+		{TInt, TInt, TInt}, {TCoord, TCoord, TCoord},
+		{TUndef, TInt, TInt}, {TUndef, TCoord, TCoord},
+	},
+	'-': {
+		{TInt, TInt, TInt}, {TCoord, TCoord, TCoord},
+		{TUndef, TInt, TInt}, {TUndef, TCoord, TCoord},
+	},
+	'*':          {{TInt, TInt, TInt}, {TCoord, TInt, TCoord}, {TInt, TCoord, TCoord}},
+	'/':          {{TInt, TInt, TInt}, {TCoord, TInt, TCoord}},
+	'%':          {{TInt, TInt, TInt}},
+	fxlex.TokPow: {{TInt, TInt, TInt}},
+}
+
+type checker struct {
+	diags ErrorList
+	fn    *Func
+}
+
+// Check infers the type of every expression in prog and verifies each
+// one has the type expected where it is used. The violations found are
+// returned as diagnostics. Programs are checked before they are run.
+func Check(prog *Prog) ErrorList {
+	c := &checker{}
+	for _, f := range prog.Funcs() {
+		c.fn = f
+		c.body(f.body)
+	}
+
+	return c.diags
+}
+
+func (c *checker) errorf(pos Pos, s string, v ...interface{}) {
+	c.diags = append(c.diags, newDiagnostic(pos, SevError, CType, fmt.Sprintf(s, v...)))
+}
+
+func (c *checker) body(b *Body) {
+	for _, stm := range b.stms {
+		c.stm(stm)
+	}
+}
+
+func (c *checker) stm(stm *Statement) {
+	switch {
+	case stm.call != nil:
+		c.call(stm.call)
+	case stm.iter != nil:
+		c.expect(stm.iter.start, TInt, "iter start")
+		c.expect(stm.iter.end, TInt, "iter end")
+		c.expect(stm.iter.step, TInt, "iter step")
+		c.body(stm.iter.body)
+	case stm.asign != nil:
+		sym := stm.asign.sym
+		c.expect(stm.asign.value, sym.Type(), fmt.Sprintf("value assigned to %s", sym.Name()))
+	case stm.nodeIf != nil:
+		for nodeIf := stm.nodeIf; nodeIf != nil; nodeIf = nodeIf.elseIf {
+			c.expect(nodeIf.cond, TBool, "if condition")
+			c.body(nodeIf.body)
+			if nodeIf.bodyElse != nil {
+				c.body(nodeIf.bodyElse)
+			}
+		}
+	case stm.body != nil:
+		c.body(stm.body)
+	case stm.while != nil:
+		c.expect(stm.while.cond, TBool, "while condition")
+		c.body(stm.while.body)
+	case stm.ret != nil:
+		c.ret(stm.ret)
+	}
+}
+
+// ret checks ret returns a value of the result type of the macro
+// it is in, or none if the macro has no result.
+func (c *checker) ret(ret *Return) {
+	head := c.fn.head
+	switch {
+	case ret.value == nil && head.result != TUndef:
+		c.errorf(ret.pos, "missing value returned from %s()", head.id)
+	case ret.value != nil && head.result == TUndef:
+		c.expr(ret.value)
+		c.errorf(ret.value.pos, "%s() returns no value", head.id)
+	case ret.value != nil:
+		c.expect(ret.value, head.result, fmt.Sprintf("value returned from %s()", head.id))
+	}
+}
+
+// call matches the arguments of call with the parameters of the
+// macro. Two int arguments stand for a Coord parameter of a builtin.
+func (c *checker) call(call *Call) {
+	f := call.f.Content().(*Func)
+	params := f.head.params
+	tps := make([]int, len(call.args))
+	groups := make([]int, len(call.args))
+	for n, arg := range call.args {
+		tps[n] = c.expr(arg)
+		groups[n] = 1
+	}
+	if f.fn != nil {
+		groups = builtinArgs(params, tps)
+	}
+
+	n := 0
+	for i, k := range groups {
+		for j := n; j < n+k && i < len(params); j++ {
+			want := params[i].Type()
+			if k == 2 {
+				want = TInt
+			}
+			c.want(call.args[j], tps[j], want, fmt.Sprintf("argument %d of %s()", j+1, f.head.id))
+		}
+		n += k
+	}
+
+	if len(groups) != len(params) {
+		c.diags = append(c.diags, newDiagnostic(call.pos, SevError, CArgs,
+			fmt.Sprintf("bad number of args calling %s()", f.head.id)))
+	}
+}
+
+// expect reports e, described by what, unless it has type want.
+func (c *checker) expect(e *Expr, want int, what string) {
+	c.want(e, c.expr(e), want, what)
+}
+
+// want reports e, of type tp, unless tp is want. Undefined types
+// are errors reported elsewhere, so they match any.
+func (c *checker) want(e *Expr, tp int, want int, what string) {
+	if tp != TUndef && want != TUndef && tp != want {
+		c.errorf(e.pos, "%s is %s, want %s", what, Types[tp], Types[want])
+	}
+}
+
+// expr sets the type of e and its subexpressions, and returns it.
+// The operators &, | and ^ on ints are given their int forms.
+func (c *checker) expr(e *Expr) int {
+	if e == nil {
+		return TUndef
+	}
+
+	e.tp = TUndef
+	switch e.op {
+	case fxlex.TokIntLit:
+		e.tp = TInt
+	case fxlex.TokBoolLit:
+		e.tp = TBool
+	case fxlex.TokID:
+		if e.sym != nil {
+			e.tp = e.sym.Type()
+		}
+	case '[':
+		c.expect(e.ELeft, TInt, "x of Coord")
+		c.expect(e.ERight, TInt, "y of Coord")
+		e.tp = TCoord
+	case '.':
+		c.expect(e.ELeft, TCoord, fmt.Sprintf("operand of .%s", e.sel))
+		e.tp = TInt
+	case '(':
+		c.call(e.call)
+		f := e.call.f.Content().(*Func)
+		if f.head.result == TUndef {
+			c.errorf(e.pos, "%s() returns no value", f.head.id)
+		}
+		e.tp = f.head.result
+	default:
+		if _, ok := bitTab[e.tok.GetTokType()]; ok && e.ELeft != nil {
+			e.op = e.tok.GetTokType()
+		}
+		opTs, ok := opTypes[e.op]
+		if !ok {
+			c.errorf(e.pos, "unknown operator %s", opString(e))
+			return TUndef
+		}
+		left := TUndef
+		if e.ELeft != nil {
+			left = c.expr(e.ELeft)
+		}
+		right := c.expr(e.ERight)
+		e.tp = c.op(e, opTs, left, right)
+		if bop, ok := bitTab[e.op]; ok && e.tp == TInt {
+			e.op = bop
+		}
+	}
+
+	return e.tp
+}
+
+// op returns the type of the result of e, whose operands have types
+// left and right, or reports them if opTs accepts no such operands.
+// Undefined operand types are errors reported elsewhere, so they match
+// any.
+func (c *checker) op(e *Expr, opTs []opType, left, right int) int {
+	isUnary := e.ELeft == nil
+	result := -1
+	for _, opT := range opTs {
+		if isUnary != (opT.left == TUndef) {
+			continue
+		}
+		if (left == TUndef || left == opT.left) && (right == TUndef || right == opT.right) {
+			if result != -1 && result != opT.result {
+				return TUndef
+			}
+			result = opT.result
+		}
+	}
+
+	switch {
+	case result != -1:
+		return result
+	case isUnary:
+		c.errorf(e.pos, "invalid operand of %s: %s", opString(e), Types[right])
+	default:
+		c.errorf(e.pos, "invalid operands of %s: %s and %s", opString(e), Types[left], Types[right])
+	}
+	return TUndef
+}
@@ -0,0 +1,124 @@
+package fxparse
+
+// Comment is a // comment of the source, as found in it.
+type Comment struct {
+	Text   string
+	pos    Pos
+	endPos Pos
+}
+
+func (c *Comment) Pos() Pos {
+	return c.pos
+}
+
+func (c *Comment) End() Pos {
+	return c.endPos
+}
+
+// comments returns the comments of the source in order. As there are
+// no string literals in fx, every // starts a comment.
+func (st *srcText) comments() (list []*Comment) {
+	for off := 0; off+1 < len(st.src); off++ {
+		if st.src[off] != '/' || st.src[off+1] != '/' {
+			continue
+		}
+		end := off
+		for end < len(st.src) && st.src[end] != '\n' && st.src[end] != '\r' {
+			end++
+		}
+		c := &Comment{string(st.src[off:end]), st.pos(off), st.pos(end)}
+		list = append(list, c)
+		off = end
+	}
+
+	return list
+}
+
+type commentQueue []*Comment
+
+// before takes the comments starting before off.
+func (q *commentQueue) before(off int) (list []*Comment) {
+	for len(*q) > 0 && (*q)[0].pos.Offset < off {
+		list = append(list, (*q)[0])
+		*q = (*q)[1:]
+	}
+
+	return list
+}
+
+// onLine takes the next comment if it is on line and starts before off.
+func (q *commentQueue) onLine(line, off int) *Comment {
+	if len(*q) == 0 || (*q)[0].pos.Line != line || (*q)[0].pos.Offset >= off {
+		return nil
+	}
+	c := (*q)[0]
+	*q = (*q)[1:]
+
+	return c
+}
+
+// attachComments gives each comment of the source to the node it
+// belongs to: the comments before a func or statement are its doc,
+// the one ending the line it starts is its line comment, and those
+// left at the end of a body or the program go with it.
+func attachComments(prog *Prog, st *srcText) {
+	q := commentQueue(st.comments())
+	for _, f := range prog.Funcs() {
+		f.doc = q.before(f.pos.Offset)
+		f.comment = q.onLine(f.pos.Line, bodyStart(f.body))
+		q.attachBody(f.body)
+	}
+	prog.endComments = q.before(len(st.src) + 1)
+}
+
+func bodyStart(b *Body) int {
+	if len(b.stms) > 0 {
+		return b.stms[0].pos.Offset
+	}
+	return b.endPos.Offset
+}
+
+func (q *commentQueue) attachBody(b *Body) {
+	for i, stm := range b.stms {
+		stm.doc = q.before(stm.pos.Offset)
+		switch {
+		case stm.iter != nil:
+			stm.comment = q.onLine(stm.pos.Line, bodyStart(stm.iter.body))
+			q.attachBody(stm.iter.body)
+		case stm.nodeIf != nil:
+			stm.comment = q.onLine(stm.pos.Line, bodyStart(stm.nodeIf.body))
+			for nodeIf := stm.nodeIf; nodeIf != nil; nodeIf = nodeIf.elseIf {
+				q.attachBody(nodeIf.body)
+				if elseIf := nodeIf.elseIf; elseIf != nil {
+					elseIf.doc, elseIf.comment = q.elseLine(elseIf.body)
+				}
+				if nodeIf.bodyElse != nil {
+					nodeIf.elseDoc, nodeIf.elseComment = q.elseLine(nodeIf.bodyElse)
+					q.attachBody(nodeIf.bodyElse)
+				}
+			}
+		case stm.while != nil:
+			stm.comment = q.onLine(stm.pos.Line, bodyStart(stm.while.body))
+			q.attachBody(stm.while.body)
+		case stm.body != nil:
+			stm.comment = q.onLine(stm.pos.Line, bodyStart(stm.body))
+			q.attachBody(stm.body)
+		default:
+			next := b.endPos.Offset
+			if i+1 < len(b.stms) {
+				next = b.stms[i+1].pos.Offset
+			}
+			stm.comment = q.onLine(stm.endPos.Line, next)
+		}
+	}
+	b.endComments = q.before(b.endPos.Offset)
+}
+
+// elseLine takes the comments of an else opening b: those between it
+// and the body before it, and the one ending its line.
+func (q *commentQueue) elseLine(b *Body) (doc []*Comment, c *Comment) {
+	doc = q.before(b.pos.Offset)
+	c = q.onLine(b.pos.Line, bodyStart(b))
+
+	return doc, c
+}
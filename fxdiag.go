@@ -0,0 +1,100 @@
+package fxparse
+
+import "fmt"
+
+const (
+	SevError = iota
+	SevWarning
+	NSevs
+)
+
+var sevNames = []string{
+	SevError:   "error",
+	SevWarning: "warning",
+}
+
+const (
+	CSyntax = iota
+	CUndefined
+	CRedefined
+	CArgs
+	CType
+	NCodes
+)
+
+var codeNames = []string{
+	CSyntax:    "syntax",
+	CUndefined: "undefined",
+	CRedefined: "redefined",
+	CArgs:      "args",
+	CType:      "type",
+}
+
+// Diagnostic is a problem found in the source, located at File:Line:Column.
+// A zero Column means the column is unknown.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity int
+	Code     int
+	Msg      string
+}
+
+func newDiagnostic(pos Pos, sev int, code int, msg string) *Diagnostic {
+	return &Diagnostic{
+		File:     pos.File,
+		Line:     pos.Line,
+		Column:   pos.Col,
+		Severity: sev,
+		Code:     code,
+		Msg:      msg,
+	}
+}
+
+func (d *Diagnostic) SevName() string {
+	if d.Severity < SevError || d.Severity >= NSevs {
+		return "unksev"
+	}
+	return sevNames[d.Severity]
+}
+
+func (d *Diagnostic) CodeName() string {
+	if d.Code < CSyntax || d.Code >= NCodes {
+		return "unkcode"
+	}
+	return codeNames[d.Code]
+}
+
+func (d *Diagnostic) Error() string {
+	place := fmt.Sprintf("%s:%d", d.File, d.Line)
+	if d.Column > 0 {
+		place += fmt.Sprintf(":%d", d.Column)
+	}
+	return fmt.Sprintf("%s: %s %s: %s", place, d.CodeName(), d.SevName(), d.Msg)
+}
+
+// ErrorList is the list of diagnostics found while parsing a file,
+// in the order they were found.
+type ErrorList []*Diagnostic
+
+func (el ErrorList) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", el[0], len(el)-1)
+}
+
+// Err returns el as an error if it holds any diagnostic of severity
+// SevError, and nil otherwise.
+func (el ErrorList) Err() error {
+	for _, d := range el {
+		if d.Severity == SevError {
+			return el
+		}
+	}
+	return nil
+}
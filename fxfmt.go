@@ -0,0 +1,248 @@
+package fxparse
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint writes prog to w as canonical fx source: one tab per level
+// of indentation, one statement per line, and only the parentheses
+// needed to keep the tree of each expression. Comments are kept if
+// the program was parsed with its source, as are single blank lines.
+func Fprint(w io.Writer, prog *Prog) error {
+	pr := &printer{}
+	pr.prog(prog)
+	_, err := w.Write(pr.buf.Bytes())
+	return err
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (pr *printer) printf(format string, v ...interface{}) {
+	fmt.Fprintf(&pr.buf, format, v...)
+}
+
+func (pr *printer) line(format string, v ...interface{}) {
+	pr.commentedLine(nil, format, v...)
+}
+
+// commentedLine is like line, but ends the line with c if it is not nil.
+func (pr *printer) commentedLine(c *Comment, format string, v ...interface{}) {
+	pr.buf.WriteString(strings.Repeat("\t", pr.indent))
+	pr.printf(format, v...)
+	if c != nil {
+		pr.printf(" %s", c.Text)
+	}
+	pr.buf.WriteByte('\n')
+}
+
+// joinComments returns the comments in doc followed by c as a single
+// one, to end a line with, or nil if there are none.
+func joinComments(doc []*Comment, c *Comment) *Comment {
+	if c != nil {
+		doc = append(doc[:len(doc):len(doc)], c)
+	}
+	if len(doc) == 0 {
+		return nil
+	}
+	texts := make([]string, len(doc))
+	for i, d := range doc {
+		texts[i] = d.Text
+	}
+
+	return &Comment{Text: strings.Join(texts, " ")}
+}
+
+// blank writes a blank line if there was any between the source
+// lines last and next. A zero line stands for none.
+func (pr *printer) blank(last, next int) {
+	if last > 0 && next > last+1 {
+		pr.buf.WriteByte('\n')
+	}
+}
+
+// doc writes the comments in doc before the node starting on line,
+// keeping single blank lines. last is the source line where the
+// output so far ends.
+func (pr *printer) doc(doc []*Comment, line, last int) {
+	for _, c := range doc {
+		pr.blank(last, c.pos.Line)
+		pr.line("%s", c.Text)
+		last = c.endPos.Line
+	}
+	pr.blank(last, line)
+}
+
+func (pr *printer) prog(prog *Prog) {
+	last := 0
+	for i, f := range prog.Funcs() {
+		if i > 0 {
+			pr.buf.WriteByte('\n')
+		}
+		pr.doc(f.doc, f.pos.Line, 0)
+		pr.fn(f)
+		last = f.endPos.Line
+	}
+	pr.doc(prog.endComments, 0, last)
+}
+
+func (pr *printer) fn(f *Func) {
+	params := make([]string, 0, len(f.head.params))
+	for _, param := range f.head.params {
+		params = append(params, fmt.Sprintf("%s %s", Types[param.Type()], param.Name()))
+	}
+	result := ""
+	if f.head.result != TUndef {
+		result = fmt.Sprintf("%s ", Types[f.head.result])
+	}
+	pr.commentedLine(f.comment, "func %s%s(%s) {", result, f.head.id, strings.Join(params, ", "))
+	pr.body(f.body)
+	pr.line("}")
+}
+
+func (pr *printer) body(b *Body) {
+	pr.indent++
+	last := 0
+	for _, stm := range b.stms {
+		pr.doc(stm.doc, stm.pos.Line, last)
+		pr.stm(stm)
+		last = stm.endPos.Line
+	}
+	pr.doc(b.endComments, 0, last)
+	pr.indent--
+}
+
+func (pr *printer) stm(stm *Statement) {
+	switch {
+	case stm.call != nil:
+		pr.commentedLine(stm.comment, "%s;", callString(stm.call))
+	case stm.decl != nil:
+		pr.commentedLine(stm.comment, "%s %s;", Types[stm.decl.Type()], stm.decl.Name())
+	case stm.asign != nil:
+		pr.commentedLine(stm.comment, "%s = %s;", stm.asign.sym.Name(), exprString(stm.asign.value))
+	case stm.iter != nil:
+		iter := stm.iter
+		incl := ""
+		if iter.incl != "" {
+			incl = iter.incl + " "
+		}
+		pr.commentedLine(stm.comment, "iter (%s := %s, %s%s, %s) {", iter.varControl.Name(),
+			exprString(iter.start), incl, exprString(iter.end), exprString(iter.step))
+		pr.body(iter.body)
+		pr.line("}")
+	case stm.nodeIf != nil:
+		nodeIf := stm.nodeIf
+		pr.commentedLine(stm.comment, "if (%s) {", exprString(nodeIf.cond))
+		pr.body(nodeIf.body)
+		for ; nodeIf.elseIf != nil; nodeIf = nodeIf.elseIf {
+			elseIf := nodeIf.elseIf
+			pr.commentedLine(joinComments(elseIf.doc, elseIf.comment), "} else if (%s) {", exprString(elseIf.cond))
+			pr.body(elseIf.body)
+		}
+		if nodeIf.bodyElse != nil {
+			pr.commentedLine(joinComments(nodeIf.elseDoc, nodeIf.elseComment), "} else {")
+			pr.body(nodeIf.bodyElse)
+		}
+		pr.line("}")
+	case stm.body != nil:
+		pr.commentedLine(stm.comment, "{")
+		pr.body(stm.body)
+		pr.line("}")
+	case stm.while != nil:
+		pr.commentedLine(stm.comment, "while (%s) {", exprString(stm.while.cond))
+		pr.body(stm.while.body)
+		pr.line("}")
+	case stm.branch != nil:
+		pr.commentedLine(stm.comment, "%s;", stm.branch.key)
+	case stm.ret != nil:
+		if stm.ret.value == nil {
+			pr.commentedLine(stm.comment, "return;")
+		} else {
+			pr.commentedLine(stm.comment, "return %s;", exprString(stm.ret.value))
+		}
+	}
+}
+
+func callString(c *Call) string {
+	args := make([]string, 0, len(c.args))
+	for _, arg := range c.args {
+		args = append(args, exprString(arg))
+	}
+
+	return fmt.Sprintf("%s(%s)", c.f.Name(), strings.Join(args, ", "))
+}
+
+// maxPrec binds tighter than any operator.
+const maxPrec = 1 << 10
+
+func exprPrec(e *Expr) int {
+	if (e.ELeft == nil && e.ERight == nil) || e.op == '[' || e.op == '(' {
+		return maxPrec
+	}
+	return opPow(e.op)
+}
+
+func exprString(e *Expr) string {
+	var b strings.Builder
+	writeExpr(&b, e, defRbp)
+	return b.String()
+}
+
+// writeExpr writes e, followed in the output by an operator binding
+// with follow. The operand of a unary operator extends to the right
+// over any operator binding tighter than it, so a unary expression
+// needs parentheses when followed by such an operator.
+func writeExpr(b *strings.Builder, e *Expr, follow int) {
+	if e == nil {
+		return
+	}
+
+	prec := exprPrec(e)
+	op := opString(e)
+	switch {
+	case e.op == '[':
+		fmt.Fprintf(b, "[%s, %s]", exprString(e.ELeft), exprString(e.ERight))
+	case e.op == '(':
+		b.WriteString(callString(e.call))
+	case e.op == '.':
+		writeOperand(b, e.ELeft, prec, true, prec)
+		fmt.Fprintf(b, ".%s", e.sel)
+	case e.ELeft == nil && e.ERight == nil:
+		b.WriteString(op)
+	case e.ELeft == nil:
+		if follow > prec {
+			b.WriteString("(")
+			defer b.WriteString(")")
+			follow = defRbp
+		}
+		b.WriteString(op)
+		if r := e.ERight; r.ELeft == nil && r.ERight != nil {
+			b.WriteString(" ")
+		}
+		writeOperand(b, e.ERight, prec, true, follow)
+	default:
+		rightAssoc := leftTab[rune(e.op)]
+		writeOperand(b, e.ELeft, prec, rightAssoc, prec)
+		fmt.Fprintf(b, " %s ", op)
+		writeOperand(b, e.ERight, prec, !rightAssoc, follow)
+	}
+}
+
+// writeOperand writes e as the operand of an operator binding with
+// prec, parenthesized if it binds looser, or as tight and tied is set.
+func writeOperand(b *strings.Builder, e *Expr, prec int, tied bool, follow int) {
+	ePrec := exprPrec(e)
+	isUnary := e.ELeft == nil && e.ERight != nil
+	if !isUnary && (ePrec < prec || (ePrec == prec && tied)) {
+		b.WriteString("(")
+		writeExpr(b, e, defRbp)
+		b.WriteString(")")
+		return
+	}
+	writeExpr(b, e, follow)
+}
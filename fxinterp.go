@@ -0,0 +1,100 @@
+package fxparse
+
+import (
+	"fmt"
+	"fxsym"
+)
+
+// Frame is a macro being run when a RuntimeError happened,
+// and the place it was running at.
+type Frame struct {
+	Func string
+	Pos  Pos
+}
+
+// RuntimeError is an error found while interpreting a program.
+// Stack holds the macros being run, innermost first.
+type RuntimeError struct {
+	Pos   Pos
+	Msg   string
+	Stack []Frame
+}
+
+func (e *RuntimeError) Error() string {
+	output := fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	for i, fr := range e.Stack {
+		if i == 0 {
+			output += fmt.Sprintf(" in %s()", fr.Func)
+		} else {
+			output += fmt.Sprintf(" called from %s()", fr.Func)
+		}
+	}
+
+	return output
+}
+
+type frame struct {
+	name string
+	from Pos
+}
+
+// Jumps out of the statements being run.
+const (
+	jumpNone = iota
+	jumpBreak
+	jumpContinue
+	jumpReturn
+)
+
+// Ctx is the state of a running program: its environments and
+// the macros being run. jump is set by break, continue and return
+// statements until the loop or macro they leave handles it, with the
+// value returned in result.
+type Ctx struct {
+	envs   *fxsym.StkEnv
+	r      Renderer
+	frames []frame
+	jump   int
+	result Value
+}
+
+func newCtx(envs *fxsym.StkEnv, r Renderer) (ctx *Ctx) {
+	return &Ctx{envs: envs, r: r}
+}
+
+// Renderer returns the renderer the program draws with.
+func (ctx *Ctx) Renderer() Renderer {
+	return ctx.r
+}
+
+func (ctx *Ctx) pushFrame(name string, from Pos) {
+	ctx.frames = append(ctx.frames, frame{name, from})
+}
+
+func (ctx *Ctx) popFrame() {
+	ctx.frames = ctx.frames[:len(ctx.frames)-1]
+}
+
+// endLoop handles a jump out of the body of a loop, and reports
+// whether the loop must end.
+func (ctx *Ctx) endLoop() bool {
+	switch ctx.jump {
+	case jumpBreak:
+		ctx.jump = jumpNone
+		return true
+	case jumpContinue:
+		ctx.jump = jumpNone
+	}
+
+	return ctx.jump == jumpReturn
+}
+
+func (ctx *Ctx) errorf(pos Pos, s string, v ...interface{}) error {
+	err := &RuntimeError{Pos: pos, Msg: fmt.Sprintf(s, v...)}
+	for i := len(ctx.frames) - 1; i >= 0; i-- {
+		err.Stack = append(err.Stack, Frame{ctx.frames[i].name, pos})
+		pos = ctx.frames[i].from
+	}
+
+	return err
+}
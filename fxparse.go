@@ -1,6 +1,9 @@
 package fxparse
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"fxlex"
 	"fxsym"
@@ -8,89 +11,170 @@ import (
 	"strings"
 )
 
-const maxErrors = 5
+// DefMaxErrors is the number of errors after which a parser gives up,
+// unless changed with SetMaxErrors.
+const DefMaxErrors = 5
+
+// errBail stops a parse that cannot go on. The reason is always
+// in the diagnostics of the parser.
+var errBail = errors.New("parse abandoned")
+
+// keywords are the words the lexer gives as identifiers which are
+// reserved to start statements.
+var keywords = map[string]bool{
+	"return":   true,
+	"while":    true,
+	"break":    true,
+	"continue": true,
+}
 
 var DebugParser bool = false
 
 var DebugTree bool = false
 
 type Parser struct {
-	l      *fxlex.Lexer
-	nErr   int
-	depth  int
-	stkEnv fxsym.StkEnv
+	l         *fxlex.Lexer
+	nErr      int
+	maxErrors int
+	bailed    bool
+	diags     ErrorList
+	depth     int
+	stkEnv    fxsym.StkEnv
+	src       *srcText
+	tokPos    Pos
+	tokEnd    Pos
+	ahead     *lexed
+	loops     int
+}
+
+// lexed is a token taken from the lexer ahead of the parser, with
+// where it was found.
+type lexed struct {
+	t        fxlex.Token
+	pos, end Pos
 }
 
 func NewParser(l *fxlex.Lexer) (p *Parser, err error) {
-	p = &Parser{l, 0, 0, nil}
+	p = &Parser{l: l, maxErrors: DefMaxErrors}
 
 	p.stkEnv.PushEnv()
-	p.initSyms()
+	if err := initSyms(&p.stkEnv); err != nil {
+		return nil, err
+	}
 	p.stkEnv.PushEnv()
 
 	return p, nil
 }
 
-func (p *Parser) Parse() error {
+// ParseProgram parses the whole input and returns the program tree
+// without running it or checking its types, see Check. If the input has
+// syntax errors, the returned error is an ErrorList holding every
+// diagnostic found.
+func (p *Parser) ParseProgram() (prog *Prog, err error) {
 	p.pushTrace("Parse")
 	defer p.popTrace()
 
-	prog := NewProg()
-	if err := p.Prog(prog); err != nil {
-		return err
+	prog = NewProg()
+	prog.pos = p.peekPos()
+	if err := p.Prog(prog); err != nil && err != errBail {
+		return nil, err
 	}
 
-	if p.nErr == 0 {
-		if DebugTree {
-			fmt.Println(prog)
-		}
+	if err := p.diags.Err(); err != nil {
+		return nil, err
+	}
 
-		p.stkEnv.PopEnv()
-		p.stkEnv.PushEnv()
-		prog.Interp(&p.stkEnv)
+	if p.src != nil {
+		attachComments(prog, p.src)
 	}
 
-	return nil
+	if DebugTree {
+		fmt.Println(prog)
+	}
+
+	return prog, nil
 }
 
-func (p *Parser) initSyms() error {
-	p.defBuiltins()
-	p.defTypes()
-	return nil
+// Parse parses the whole input and interprets it in a fresh environment.
+// Use NewSourceParser for the errors returned to have columns.
+func (p *Parser) Parse() error {
+	prog, err := p.ParseProgram()
+	if err != nil {
+		return err
+	}
+
+	envs, err := NewEnv()
+	if err != nil {
+		return err
+	}
+
+	return prog.Interp(envs)
 }
 
-func (p *Parser) defBuiltins() error {
-	for name, builtin := range builtins {
-		f := NewFunc()
-		f.head.id = builtin.name
+// NewEnv returns an environment holding the builtins and the basic types,
+// ready to interpret a program in.
+func NewEnv() (envs *fxsym.StkEnv, err error) {
+	envs = &fxsym.StkEnv{}
 
-		p.stkEnv.PushEnv()
-		for i, arg := range builtin.args {
-			vSym, err := p.stkEnv.NewSym(arg, fxsym.SVar)
-			if err != nil {
-				return err
-			}
-			vSym.AddTokKind(fxlex.TokKey)
-			vSym.AddPlace("builtin", i)
-			f.head.AddParam(vSym)
+	envs.PushEnv()
+	if err := initSyms(envs); err != nil {
+		return nil, err
+	}
+	envs.PushEnv()
+
+	return envs, nil
+}
+
+func initSyms(envs *fxsym.StkEnv) error {
+	if err := defBuiltins(envs); err != nil {
+		return err
+	}
+	return defTypes(envs)
+}
+
+func defBuiltins(envs *fxsym.StkEnv) error {
+	for _, builtin := range builtins {
+		if err := defBuiltin(envs, builtin); err != nil {
+			return err
 		}
-		p.stkEnv.PopEnv()
+	}
+
+	return nil
+}
 
-		fSym, err := p.stkEnv.NewSym(name, fxsym.SFunc)
+func defBuiltin(envs *fxsym.StkEnv, builtin Builtin) error {
+	f := NewFunc()
+	f.head.id = builtin.name
+	f.fn = builtin.fn
+
+	envs.PushEnv()
+	for i, param := range builtin.params {
+		vSym, err := envs.NewSym(param.Name, fxsym.SVar)
 		if err != nil {
+			envs.PopEnv()
 			return err
 		}
-		fSym.AddTokKind(builtin.kind)
-		fSym.AddPlace("builtin", 0)
-		fSym.AddContent(f)
+		vSym.AddTokKind(fxlex.TokKey)
+		vSym.AddPlace("builtin", i)
+		vSym.SetType(param.Type)
+		f.head.AddParam(vSym)
+	}
+	envs.PopEnv()
+
+	fSym, err := envs.NewSym(builtin.name, fxsym.SFunc)
+	if err != nil {
+		return err
 	}
+	fSym.AddTokKind(builtin.kind)
+	fSym.AddPlace("builtin", 0)
+	fSym.AddContent(f)
 
 	return nil
 }
 
-func (p *Parser) defTypes() error {
+func defTypes(envs *fxsym.StkEnv) error {
 	for _, tp := range Types {
-		tSym, err := p.stkEnv.NewSym(tp.String(), fxsym.SType)
+		tSym, err := envs.NewSym(tp.String(), fxsym.SType)
 		if err != nil {
 			return err
 		}
@@ -102,32 +186,244 @@ func (p *Parser) defTypes() error {
 	return nil
 }
 
-func (p *Parser) match(tT int) (t fxlex.Token, isMatch bool, e error) {
+// NewSourceParser returns a parser reading src, whose positions name
+// file and include columns and offsets.
+func NewSourceParser(file string, src []byte) (p *Parser, err error) {
+	l, err := fxlex.NewLexer(bufio.NewReader(bytes.NewReader(src)), file)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = NewParser(l); err != nil {
+		return nil, err
+	}
+	p.SetSource(src)
+
+	return p, nil
+}
+
+// SetSource gives the parser the text the lexer is reading, so that
+// positions include columns and offsets. It must be called before parsing.
+func (p *Parser) SetSource(src []byte) {
+	p.src = newSrcText(p.l.GetFilename(), src)
+}
+
+// SetMaxErrors sets the number of errors after which the parser gives up.
+// If n <= 0, the parser goes on until the end of the input.
+func (p *Parser) SetMaxErrors(n int) {
+	p.maxErrors = n
+}
+
+func (p *Parser) peek() (t fxlex.Token, err error) {
+	if p.bailed {
+		return fxlex.Token{}, errBail
+	}
+	if p.ahead != nil {
+		return p.ahead.t, nil
+	}
+	if t, err = p.l.Peek(); err != nil {
+		return t, p.lexFail(err)
+	}
+	return t, nil
+}
+
+// peek2 returns the token after the next one.
+func (p *Parser) peek2() (t fxlex.Token, err error) {
+	if p.bailed {
+		return fxlex.Token{}, errBail
+	}
+	if p.ahead == nil {
+		a, err := p.lexLexer()
+		if err != nil {
+			return a.t, err
+		}
+		p.ahead = &a
+	}
+	if t, err = p.l.Peek(); err != nil {
+		return t, p.lexFail(err)
+	}
+	return t, nil
+}
+
+func (p *Parser) lex() (t fxlex.Token, err error) {
+	if p.bailed {
+		return fxlex.Token{}, errBail
+	}
+	a := p.ahead
+	if a == nil {
+		l, err := p.lexLexer()
+		if err != nil {
+			return l.t, err
+		}
+		a = &l
+	}
+	p.ahead = nil
+	p.tokPos, p.tokEnd = a.pos, a.end
+
+	return a.t, nil
+}
+
+// lexLexer takes the next token from the lexer and places it.
+func (p *Parser) lexLexer() (a lexed, err error) {
+	a.t, err = p.l.Lex()
+	if err != nil {
+		return a, p.lexFail(err)
+	}
+
+	a.pos, a.end = p.pos(), p.pos()
+	if p.src == nil {
+		return a, nil
+	}
+	if a.t.GetTokType() == fxlex.TokEOF {
+		a.pos = p.src.eof()
+		a.end = a.pos
+	} else if pos, end, ok := p.src.place(a.t.GetLexeme()); ok {
+		a.pos, a.end = pos, end
+	}
+
+	return a, nil
+}
+
+// peekPos returns where the next token starts.
+func (p *Parser) peekPos() Pos {
+	if p.ahead != nil {
+		return p.ahead.pos
+	}
+	pos := p.pos()
+	if p.src == nil {
+		return pos
+	}
 	t, err := p.l.Peek()
+	if err != nil {
+		return pos
+	}
+	if t.GetTokType() == fxlex.TokEOF {
+		return p.src.pos(len(p.src.src))
+	}
+	if tPos, ok := p.src.peek(t.GetLexeme()); ok {
+		return tPos
+	}
+
+	return pos
+}
+
+func (p *Parser) skipUntil(tTs ...int) error {
+	if p.bailed {
+		return errBail
+	}
+	if p.ahead != nil {
+		for _, tT := range tTs {
+			if p.ahead.t.GetTokType() == tT {
+				return nil
+			}
+		}
+		p.ahead = nil
+	}
+	p.placeUntil(tTs...)
+	if err := p.l.SkipUntil(tTs...); err != nil {
+		return p.lexFail(err)
+	}
+	return nil
+}
+
+func (p *Parser) skipUntilAndLex(tT int) error {
+	if p.bailed {
+		return errBail
+	}
+	if p.ahead != nil {
+		isTok := p.ahead.t.GetTokType() == tT
+		p.ahead = nil
+		if isTok {
+			return nil
+		}
+	}
+	p.placeUntil(tT)
+	if t, err := p.l.Peek(); err == nil && p.src != nil && t.GetTokType() == tT {
+		_, err = p.lexLexer()
+		return err
+	}
+	if err := p.l.SkipUntilAndLex(tT); err != nil {
+		return p.lexFail(err)
+	}
+	return nil
+}
+
+// lexFail records err, given by the lexer, as a syntax error and gives
+// up the parse.
+func (p *Parser) lexFail(err error) error {
+	p.errorf(CSyntax, "%s", err)
+	p.bailed = true
+	return errBail
+}
+
+// placeUntil takes from the lexer the tokens before the next one of
+// types tTs, or EOF, placing them in the source so that the tokens
+// after them can be placed too. It does nothing without a source.
+func (p *Parser) placeUntil(tTs ...int) {
+	if p.src == nil {
+		return
+	}
+	for {
+		t, err := p.l.Peek()
+		if err != nil || t.GetTokType() == fxlex.TokEOF {
+			return
+		}
+		for _, tT := range tTs {
+			if t.GetTokType() == tT {
+				return
+			}
+		}
+		if _, err := p.lexLexer(); err != nil {
+			return
+		}
+	}
+}
+
+func (p *Parser) match(tT int) (t fxlex.Token, isMatch bool, e error) {
+	t, err := p.peek()
 	if err != nil {
 		return fxlex.Token{}, false, err
 	}
 
 	if t.GetTokType() != tT {
 		if t.GetTokType() == fxlex.TokEOF {
-			panic("unexpected EOF")
+			p.errorf(CSyntax, "unexpected EOF")
+			return t, false, errBail
 		}
 
 		return t, false, nil
 	}
 
-	t, _ = p.l.Lex()
-	return t, true, nil
+	t, err = p.lex()
+	return t, err == nil, err
 }
 
-func (p *Parser) errorf(s string, v ...interface{}) {
-	fmt.Fprintf(os.Stderr, s+"\n", v...)
+// reserved reports t, the name just declared, if it is a keyword.
+func (p *Parser) reserved(t fxlex.Token) {
+	if keywords[t.GetLexeme()] {
+		p.errorfAt(p.tokPos, CSyntax, "%s is reserved and cannot be declared", t.GetLexeme())
+	}
+}
+
+func (p *Parser) errorf(code int, s string, v ...interface{}) {
+	p.errorfAt(p.peekPos(), code, s, v...)
+}
+
+func (p *Parser) errorfAt(pos Pos, code int, s string, v ...interface{}) {
+	if p.bailed {
+		return
+	}
+
+	p.diags = append(p.diags, newDiagnostic(pos, SevError, code, fmt.Sprintf(s, v...)))
 	p.nErr++
-	if p.nErr >= maxErrors {
-		panic("too many errors")
+	if p.maxErrors > 0 && p.nErr >= p.maxErrors {
+		p.bailed = true
 	}
 }
 
+func (p *Parser) pos() Pos {
+	return Pos{File: p.l.GetFilename(), Line: p.l.GetLineNumber()}
+}
+
 func (p *Parser) pushTrace(tag string) {
 	if DebugParser {
 		tabs := strings.Repeat("\t", p.depth)
@@ -146,14 +442,14 @@ func (p *Parser) Prog(prog *Prog) error {
 	p.pushTrace("Prog")
 	defer p.popTrace()
 
-	t, err := p.l.Peek()
+	t, err := p.peek()
 	if err != nil {
 		return err
 	}
 
 	switch t.GetTokType() {
 	case fxlex.TokFunc:
-		t, err = p.l.Lex()
+		t, err = p.lex()
 		p.pushTrace("\"func\"")
 		p.popTrace()
 
@@ -164,8 +460,7 @@ func (p *Parser) Prog(prog *Prog) error {
 
 		fSym, err := p.stkEnv.NewSym(f.head.id, fxsym.SFunc)
 		if err != nil {
-			p.errorf("%s:%d: syntax error: %s (%s)",
-				p.l.GetFilename(), p.l.GetLineNumber(), err, f.head.id)
+			p.errorf(CRedefined, "%s (%s)", err, f.head.id)
 		} else {
 			fSym.AddTokKind(fxlex.TokFunc)
 			fSym.AddPlace(p.l.GetFilename(), p.l.GetLineNumber())
@@ -176,12 +471,12 @@ func (p *Parser) Prog(prog *Prog) error {
 
 		return p.Prog(prog)
 	case fxlex.TokEOF:
-		t, err = p.l.Lex()
+		t, err = p.lex()
+		prog.endPos = p.tokPos
 		p.pushTrace("\"EOF\"")
 		p.popTrace()
 	default:
-		p.errorf("%s:%d: syntax error: expected func or EOF, found %s",
-			p.l.GetFilename(), p.l.GetLineNumber(), t.GetTokType())
+		p.errorf(CSyntax, "expected func or EOF, found %s", t)
 	}
 
 	return err
@@ -196,6 +491,7 @@ func (p *Parser) Func() (f *Func, err error) {
 	defer p.stkEnv.PopEnv()
 
 	f = NewFunc()
+	f.pos = p.tokPos
 
 	if err := p.Head(f.head); err != nil {
 		return nil, err
@@ -205,9 +501,8 @@ func (p *Parser) Func() (f *Func, err error) {
 	if err != nil {
 		return nil, err
 	} else if !isLCurl {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
 		if err != nil {
 			return nil, err
 		}
@@ -215,6 +510,7 @@ func (p *Parser) Func() (f *Func, err error) {
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 	}
+	f.body.pos = p.tokPos
 
 	if err := p.Body(f.body); err != nil {
 		return nil, err
@@ -224,36 +520,50 @@ func (p *Parser) Func() (f *Func, err error) {
 	if err != nil {
 		return nil, err
 	} else if !isRCurl {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntilAndLex(fxlex.TokRCurl)
-		return nil, err
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntilAndLex(fxlex.TokRCurl)
+		return f, err
 	} else {
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 	}
+	f.body.endPos = p.tokEnd
+	f.endPos = p.tokEnd
 
 	return f, err
 }
 
-// <HEAD> ::= id '(' <FORMAL_PRMS> ')'
+// <HEAD> ::= type_id id '(' <FORMAL_PRMS> ')' |
+//            id '(' <FORMAL_PRMS> ')'
 func (p *Parser) Head(head *Head) error {
 	p.pushTrace("Head")
 	defer p.popTrace()
 
+	head.pos = p.peekPos()
+
 	t, isID, err := p.match(fxlex.TokID)
 	if err != nil {
 		return err
-	} else if !isID {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLPar, fxlex.TokRPar, fxlex.TokComma)
+	}
+	if tSym := p.stkEnv.GetSym(t.GetLexeme()); isID && tSym != nil && tSym.SymType() == "SType" {
+		p.pushTrace(fmt.Sprintf("TypeID %s", t))
+		p.popTrace()
+
+		head.result = tSym.Content().(*Type).id
+		if t, isID, err = p.match(fxlex.TokID); err != nil {
+			return err
+		}
+	}
+	if !isID {
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntil(fxlex.TokLPar, fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
 	} else {
 		p.pushTrace(fmt.Sprintf("ID %s", t))
 		p.popTrace()
+		p.reserved(t)
 
 		head.id = t.GetLexeme()
 	}
@@ -262,9 +572,8 @@ func (p *Parser) Head(head *Head) error {
 	if err != nil {
 		return err
 	} else if !isLPar {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntil(fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
@@ -281,14 +590,14 @@ func (p *Parser) Head(head *Head) error {
 	if err != nil {
 		return err
 	} else if !isRPar {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntilAndLex(fxlex.TokRPar)
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntilAndLex(fxlex.TokRPar)
 		return err
 	} else {
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 	}
+	head.endPos = p.tokEnd
 
 	return err
 }
@@ -309,11 +618,9 @@ func (p *Parser) FormalPrms(head *Head) error {
 
 	tSym := p.stkEnv.GetSym(tokType.GetLexeme())
 	if tSym == nil {
-		p.errorf("%s:%d: syntax error: type %s not found",
-			p.l.GetFilename(), p.l.GetLineNumber(), tokType.GetLexeme())
+		p.errorf(CUndefined, "type %s not found", tokType.GetLexeme())
 	} else if tSym.SymType() != "SType" {
-		p.errorf("%s:%d: syntax error: expecting type, found %s",
-			p.l.GetFilename(), p.l.GetLineNumber(), tokType.GetLexeme())
+		p.errorf(CSyntax, "expecting type, found %s", tokType.GetLexeme())
 		tSym = nil
 	}
 
@@ -321,21 +628,20 @@ func (p *Parser) FormalPrms(head *Head) error {
 	if err != nil {
 		return err
 	} else if !isID {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntil(fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
 	} else {
 		p.pushTrace(fmt.Sprintf("ID %s", tokID))
 		p.popTrace()
+		p.reserved(tokID)
 	}
 
 	vSym, err := p.stkEnv.NewSym(tokID.GetLexeme(), fxsym.SVar)
 	if err != nil {
-		p.errorf("%s:%d: syntax error: %s (%s)",
-			p.l.GetFilename(), p.l.GetLineNumber(), err, tokID.GetLexeme())
+		p.errorf(CRedefined, "%s (%s)", err, tokID.GetLexeme())
 	} else {
 		vSym.AddTokKind(tokType.GetTokType())
 		vSym.AddPlace(p.l.GetFilename(), p.l.GetLineNumber())
@@ -367,9 +673,8 @@ func (p *Parser) Prms(head *Head) error {
 	if err != nil {
 		return err
 	} else if !isTypeID {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntil(fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
@@ -380,11 +685,9 @@ func (p *Parser) Prms(head *Head) error {
 
 	tSym := p.stkEnv.GetSym(tokType.GetLexeme())
 	if tSym == nil {
-		p.errorf("%s:%d: syntax error: type %s not found",
-			p.l.GetFilename(), p.l.GetLineNumber(), tokType.GetLexeme())
+		p.errorf(CUndefined, "type %s not found", tokType.GetLexeme())
 	} else if tSym.SymType() != "SType" {
-		p.errorf("%s:%d: syntax error: expecting type, found %s",
-			p.l.GetFilename(), p.l.GetLineNumber(), tokType.GetLexeme())
+		p.errorf(CSyntax, "expecting type, found %s", tokType.GetLexeme())
 		tSym = nil
 	}
 
@@ -392,21 +695,20 @@ func (p *Parser) Prms(head *Head) error {
 	if err != nil {
 		return err
 	} else if !isID {
-		p.errorf("%s:%d: syntax error: macro bad definition",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "macro bad definition")
+		err = p.skipUntil(fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
 	} else {
 		p.pushTrace(fmt.Sprintf("ID %s", tokID))
 		p.popTrace()
+		p.reserved(tokID)
 	}
 
 	vSym, err := p.stkEnv.NewSym(tokID.GetLexeme(), fxsym.SVar)
 	if err != nil {
-		p.errorf("%s:%d: syntax error: %s (%s)",
-			p.l.GetFilename(), p.l.GetLineNumber(), err, tokID.GetLexeme())
+		p.errorf(CRedefined, "%s (%s)", err, tokID.GetLexeme())
 	} else {
 		vSym.AddTokKind(tokType.GetTokType())
 		vSym.AddPlace(p.l.GetFilename(), p.l.GetLineNumber())
@@ -422,6 +724,10 @@ func (p *Parser) Prms(head *Head) error {
 
 // <BODY> ::= id '(' <CALL> <BODY> |
 //            'iter' <ITER> <BODY> |
+//            'while' <WHILE> <BODY> |
+//            'break' ';' <BODY> |
+//            'continue' ';' <BODY> |
+//            'return' <RETURN> <BODY> |
 //            type_id id ; <BODY> |
 //            var_id '=' <EXPR> ';' <BODY> |
 //            '{' <BODY> '}' |
@@ -433,37 +739,46 @@ func (p *Parser) Body(body *Body) error {
 	p.stkEnv.PushEnv()
 	defer p.stkEnv.PopEnv()
 
-	t, err := p.l.Peek()
+	t, err := p.peek()
 	if err != nil {
 		return err
 	}
 
 	stm := NewStatement()
+	stm.pos = p.peekPos()
 
-	switch t.GetTokType() {
+	tT := t.GetTokType()
+	if tT == fxlex.TokID && keywords[t.GetLexeme()] {
+		tT = fxlex.TokKey
+	}
+
+	switch tT {
 	case fxlex.TokID:
-		tokID, _ := p.l.Lex()
+		tokID, _ := p.lex()
 		p.pushTrace(fmt.Sprintf("ID %s", tokID))
 		p.popTrace()
 
 		sym := p.stkEnv.GetSym(tokID.GetLexeme())
 		if sym == nil {
-			p.errorf("%s:%d: syntax error: symbol %s not found",
-				p.l.GetFilename(), p.l.GetLineNumber(), tokID.GetLexeme())
+			p.errorfAt(p.tokPos, CUndefined, "symbol %s not found", tokID.GetLexeme())
+			if err := p.skipUntilAndLex(fxlex.Semicolon); err != nil {
+				return err
+			}
+			return p.Body(body)
 		}
 
 		switch sym.SymType() {
 		case "SFunc":
 			call := NewCall()
 			call.AddFunc(sym)
+			call.pos = p.tokPos
 
 			t, isLPar, err := p.match(fxlex.TokLPar)
 			if err != nil {
 				return err
 			} else if !isLPar {
-				p.errorf("%s:%d: syntax error: bad statement",
-					p.l.GetFilename(), p.l.GetLineNumber())
-				err = p.l.SkipUntil(fxlex.TokRPar, fxlex.TokComma, fxlex.Semicolon)
+				p.errorf(CSyntax, "bad statement")
+				err = p.skipUntil(fxlex.TokRPar, fxlex.TokComma, fxlex.Semicolon)
 				if err != nil {
 					return err
 				}
@@ -475,11 +790,7 @@ func (p *Parser) Body(body *Body) error {
 			if err := p.Call(call); err != nil {
 				return err
 			}
-
-			if len(call.f.Content().(*Func).head.params) != len(call.args) {
-				p.errorf("%s:%d: syntax error: bad number of args",
-					p.l.GetFilename(), p.l.GetLineNumber())
-			}
+			call.endPos = p.tokEnd
 
 			stm.AddCall(call)
 		case "SType":
@@ -487,21 +798,20 @@ func (p *Parser) Body(body *Body) error {
 			if err != nil {
 				return err
 			} else if !isID {
-				p.errorf("%s:%d: syntax error: bad statement",
-					p.l.GetFilename(), p.l.GetLineNumber())
-				err = p.l.SkipUntil(fxlex.Semicolon)
+				p.errorf(CSyntax, "bad statement")
+				err = p.skipUntil(fxlex.Semicolon)
 				if err != nil {
 					return err
 				}
 			} else {
 				p.pushTrace(fmt.Sprintf("ID %s", tokID))
 				p.popTrace()
+				p.reserved(tokID)
 			}
 
 			vSym, err := p.stkEnv.NewSym(tokID.GetLexeme(), fxsym.SVar)
 			if err != nil {
-				p.errorf("%s:%d: syntax error: %s (%s)",
-					p.l.GetFilename(), p.l.GetLineNumber(), err, tokID.GetLexeme())
+				p.errorf(CRedefined, "%s (%s)", err, tokID.GetLexeme())
 			} else {
 				if sym != nil {
 					vSym.SetType(sym.Content().(*Type).id)
@@ -513,9 +823,8 @@ func (p *Parser) Body(body *Body) error {
 			if err != nil {
 				return err
 			} else if !isSemicolon {
-				p.errorf("%s:%d: syntax error: bad statement",
-					p.l.GetFilename(), p.l.GetLineNumber())
-				err = p.l.SkipUntilAndLex(fxlex.Semicolon)
+				p.errorf(CSyntax, "bad statement")
+				err = p.skipUntilAndLex(fxlex.Semicolon)
 				return err
 			} else {
 				p.pushTrace(fmt.Sprintf("%s", t))
@@ -526,14 +835,14 @@ func (p *Parser) Body(body *Body) error {
 		case "SVar":
 			asign := NewAsign()
 			asign.AddSym(sym)
+			asign.pos = p.tokPos
 
 			t, isEqual, err := p.match(fxlex.Assignation)
 			if err != nil {
 				return err
 			} else if !isEqual {
-				p.errorf("%s:%d: syntax error: bad statement",
-					p.l.GetFilename(), p.l.GetLineNumber())
-				err = p.l.SkipUntil(fxlex.Semicolon)
+				p.errorf(CSyntax, "bad statement")
+				err = p.skipUntil(fxlex.Semicolon)
 				if err != nil {
 					return err
 				}
@@ -552,22 +861,21 @@ func (p *Parser) Body(body *Body) error {
 			if err != nil {
 				return err
 			} else if !isSemicolon {
-				p.errorf("%s:%d: syntax error: bad statement",
-					p.l.GetFilename(), p.l.GetLineNumber())
-				err = p.l.SkipUntilAndLex(fxlex.Semicolon)
+				p.errorf(CSyntax, "bad statement")
+				err = p.skipUntilAndLex(fxlex.Semicolon)
 				return err
 			} else {
 				p.pushTrace(fmt.Sprintf("%s", t))
 				p.popTrace()
 			}
+			asign.endPos = p.tokEnd
 
 			stm.AddAsign(asign)
 		default:
-			p.errorf("%s:%d: syntax error: symbol %s not expected",
-				p.l.GetFilename(), p.l.GetLineNumber(), tokID.GetLexeme())
+			p.errorfAt(p.tokPos, CSyntax, "symbol %s not expected", tokID.GetLexeme())
 		}
 	case fxlex.TokKey:
-		t, err = p.l.Lex()
+		t, err = p.lex()
 
 		switch t.GetLexeme() {
 		case "iter":
@@ -575,9 +883,11 @@ func (p *Parser) Body(body *Body) error {
 			p.popTrace()
 
 			iter := NewIter()
+			iter.pos = p.tokPos
 			if err := p.Iter(iter); err != nil {
 				return err
 			}
+			iter.endPos = p.tokEnd
 
 			stm.AddIter(iter)
 		case "if":
@@ -585,26 +895,76 @@ func (p *Parser) Body(body *Body) error {
 			p.popTrace()
 
 			nodeIf := NewNodeIf()
+			nodeIf.pos = p.tokPos
 			if err := p.NodeIf(nodeIf); err != nil {
 				return err
 			}
+			nodeIf.endPos = p.tokEnd
 
 			stm.AddNodeIf(nodeIf)
+		case "return":
+			p.pushTrace(fmt.Sprintf("Key %s", t))
+			p.popTrace()
+
+			ret := NewReturn()
+			ret.pos = p.tokPos
+			if err := p.Return(ret); err != nil {
+				return err
+			}
+			ret.endPos = p.tokEnd
+
+			stm.AddReturn(ret)
+		case "while":
+			p.pushTrace(fmt.Sprintf("Key %s", t))
+			p.popTrace()
+
+			while := NewWhile()
+			while.pos = p.tokPos
+			if err := p.While(while); err != nil {
+				return err
+			}
+			while.endPos = p.tokEnd
+
+			stm.AddWhile(while)
+		case "break", "continue":
+			p.pushTrace(fmt.Sprintf("Key %s", t))
+			p.popTrace()
+
+			branch := NewBranch(t.GetLexeme())
+			branch.pos = p.tokPos
+			if p.loops == 0 {
+				p.errorfAt(p.tokPos, CSyntax, "%s outside a loop", t.GetLexeme())
+			}
+
+			t, isSemicolon, err := p.match(fxlex.Semicolon)
+			if err != nil {
+				return err
+			} else if !isSemicolon {
+				p.errorf(CSyntax, "bad statement")
+				err = p.skipUntilAndLex(fxlex.Semicolon)
+				return err
+			} else {
+				p.pushTrace(fmt.Sprintf("%s", t))
+				p.popTrace()
+			}
+			branch.endPos = p.tokEnd
+
+			stm.AddBranch(branch)
 		default:
-			p.errorf("%s:%d: syntax error: keyword unexpected",
-				p.l.GetFilename(), p.l.GetLineNumber())
-			err = p.l.SkipUntil(fxlex.TokLPar, fxlex.TokRPar, fxlex.TokComma)
+			p.errorf(CSyntax, "keyword unexpected")
+			err = p.skipUntil(fxlex.TokLPar, fxlex.TokRPar, fxlex.TokComma)
 			if err != nil {
 				return err
 			}
 		}
 	case fxlex.TokLCurl:
-		t, _ = p.l.Lex()
+		t, _ = p.lex()
 
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 
 		inner_body := NewBody()
+		inner_body.pos = p.tokPos
 		if err := p.Body(inner_body); err != nil {
 			return err
 		}
@@ -613,20 +973,21 @@ func (p *Parser) Body(body *Body) error {
 		if err != nil {
 			return err
 		} else if !isRCurl {
-			p.errorf("%s:%d: syntax error: bad statement",
-				p.l.GetFilename(), p.l.GetLineNumber())
-			err = p.l.SkipUntilAndLex(fxlex.TokRCurl)
+			p.errorf(CSyntax, "bad statement")
+			err = p.skipUntilAndLex(fxlex.TokRCurl)
 			return err
 		} else {
 			p.pushTrace(fmt.Sprintf("%s", t))
 			p.popTrace()
 		}
+		inner_body.endPos = p.tokEnd
 
 		stm.AddBody(inner_body)
 	default:
 		return err
 	}
 
+	stm.endPos = p.tokEnd
 	body.AddStm(stm)
 
 	return p.Body(body)
@@ -651,9 +1012,8 @@ func (p *Parser) Call(call *Call) error {
 		if err != nil {
 			return err
 		} else if !isRPar {
-			p.errorf("%s:%d: syntax error: bad statement",
-				p.l.GetFilename(), p.l.GetLineNumber())
-			err = p.l.SkipUntil(fxlex.Semicolon)
+			p.errorf(CSyntax, "bad statement")
+			err = p.skipUntil(fxlex.Semicolon)
 			if err != nil {
 				return err
 			}
@@ -670,9 +1030,8 @@ func (p *Parser) Call(call *Call) error {
 	if err != nil {
 		return err
 	} else if !isSemicolon {
-		p.errorf("%s:%d: syntax error: bad statement",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntilAndLex(fxlex.Semicolon)
+		p.errorf(CSyntax, "bad statement")
+		err = p.skipUntilAndLex(fxlex.Semicolon)
 		return err
 	} else {
 		p.pushTrace(fmt.Sprintf("%s", t))
@@ -719,7 +1078,41 @@ func (p *Parser) Args(call *Call) error {
 	return p.Args(call)
 }
 
-// <ITER> ::= '(' id ':=' <EXPR> ',' <EXPR> ',' <EXPR> ')' '{' <BODY> '}'
+// <RETURN> ::= <EXPR> ';' |
+//              ';'
+func (p *Parser) Return(ret *Return) error {
+	p.pushTrace("Return")
+	defer p.popTrace()
+
+	t, err := p.peek()
+	if err != nil {
+		return err
+	}
+	if t.GetTokType() != fxlex.Semicolon {
+		e, err := p.Expr(defRbp - 1)
+		if err != nil {
+			return err
+		}
+		ret.AddValue(e)
+	}
+
+	t, isSemicolon, err := p.match(fxlex.Semicolon)
+	if err != nil {
+		return err
+	} else if !isSemicolon {
+		p.errorf(CSyntax, "return (bad statement)")
+		err = p.skipUntilAndLex(fxlex.Semicolon)
+		return err
+	} else {
+		p.pushTrace(fmt.Sprintf("%s", t))
+		p.popTrace()
+	}
+
+	return err
+}
+
+// <ITER> ::= '(' id ':=' <EXPR> ',' <INCL> <EXPR> ',' <EXPR> ')' '{' <BODY> '}'
+// <INCL> ::= '<=' | '>=' | <Empty>
 func (p *Parser) Iter(iter *Iter) error {
 	p.pushTrace("Iter")
 	defer p.popTrace()
@@ -731,9 +1124,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isLPar {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
@@ -746,21 +1138,20 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isID {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
 	} else {
 		p.pushTrace(fmt.Sprintf("ID %s", t))
 		p.popTrace()
+		p.reserved(t)
 	}
 
 	varControl, err := p.stkEnv.NewSym(t.GetLexeme(), fxsym.SVar)
 	if err != nil {
-		p.errorf("%s:%d: syntax error: %s (%s)",
-			p.l.GetFilename(), p.l.GetLineNumber(), err, t.GetLexeme())
+		p.errorf(CRedefined, "%s (%s)", err, t.GetLexeme())
 	} else {
 		varControl.AddTokKind(t.GetTokType())
 		varControl.AddPlace(p.l.GetFilename(), p.l.GetLineNumber())
@@ -773,9 +1164,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isDeclaration {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
@@ -795,9 +1185,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isComma {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar, fxlex.TokComma)
 		if err != nil {
 			return err
 		}
@@ -806,6 +1195,18 @@ func (p *Parser) Iter(iter *Iter) error {
 		p.popTrace()
 	}
 
+	t, err = p.peek()
+	if err != nil {
+		return err
+	}
+	if t.GetTokType() == fxlex.TokLTE || t.GetTokType() == fxlex.TokGTE {
+		p.lex()
+		p.pushTrace(fmt.Sprintf("%s", t))
+		p.popTrace()
+
+		iter.incl = t.GetLexeme()
+	}
+
 	e, err = p.Expr(defRbp - 1)
 	if err != nil {
 		return err
@@ -817,9 +1218,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isComma {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar)
 		if err != nil {
 			return err
 		}
@@ -839,9 +1239,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isRPar {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -854,9 +1253,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isLCurl {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRCurl)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntil(fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -865,7 +1263,11 @@ func (p *Parser) Iter(iter *Iter) error {
 		p.popTrace()
 	}
 
-	if err = p.Body(iter.body); err != nil {
+	iter.body.pos = p.tokPos
+	p.loops++
+	err = p.Body(iter.body)
+	p.loops--
+	if err != nil {
 		return err
 	}
 
@@ -873,9 +1275,8 @@ func (p *Parser) Iter(iter *Iter) error {
 	if err != nil {
 		return err
 	} else if !isRCurl {
-		p.errorf("%s:%d: syntax error: iter (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntilAndLex(fxlex.TokRCurl)
+		p.errorf(CSyntax, "iter (bad statement)")
+		err = p.skipUntilAndLex(fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -883,6 +1284,7 @@ func (p *Parser) Iter(iter *Iter) error {
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 	}
+	iter.body.endPos = p.tokEnd
 
 	return err
 }
@@ -896,9 +1298,8 @@ func (p *Parser) NodeIf(nodeIf *NodeIf) error {
 	if err != nil {
 		return err
 	} else if !isLPar {
-		p.errorf("%s:%d: syntax error: if (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar)
+		p.errorf(CSyntax, "if (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar)
 		if err != nil {
 			return err
 		}
@@ -918,9 +1319,8 @@ func (p *Parser) NodeIf(nodeIf *NodeIf) error {
 	if err != nil {
 		return err
 	} else if !isRPar {
-		p.errorf("%s:%d: syntax error: if (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
+		p.errorf(CSyntax, "if (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -933,9 +1333,8 @@ func (p *Parser) NodeIf(nodeIf *NodeIf) error {
 	if err != nil {
 		return err
 	} else if !isLCurl {
-		p.errorf("%s:%d: syntax error: if (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRCurl)
+		p.errorf(CSyntax, "if (bad statement)")
+		err = p.skipUntil(fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -944,6 +1343,7 @@ func (p *Parser) NodeIf(nodeIf *NodeIf) error {
 		p.popTrace()
 	}
 
+	nodeIf.body.pos = p.tokPos
 	if err = p.Body(nodeIf.body); err != nil {
 		return err
 	}
@@ -952,9 +1352,8 @@ func (p *Parser) NodeIf(nodeIf *NodeIf) error {
 	if err != nil {
 		return err
 	} else if !isRCurl {
-		p.errorf("%s:%d: syntax error: if (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntilAndLex(fxlex.TokRCurl)
+		p.errorf(CSyntax, "if (bad statement)")
+		err = p.skipUntilAndLex(fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -962,30 +1361,51 @@ func (p *Parser) NodeIf(nodeIf *NodeIf) error {
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 	}
+	nodeIf.body.endPos = p.tokEnd
 
 	return p.Else(nodeIf)
 }
 
-// <ELSE> ::= 'else' '{' <BODY> '}' |
+// <ELSE> ::= 'else' 'if' <IF> |
+//            'else' '{' <BODY> '}' |
 //            <Empty>
 func (p *Parser) Else(nodeIf *NodeIf) error {
 	p.pushTrace("Else")
 	defer p.popTrace()
 
-	tokElse, err := p.l.Peek()
+	tokElse, err := p.peek()
 	if err != nil || tokElse.GetLexeme() != "else" {
 		return err
 	}
 
-	p.l.Lex()
+	p.lex()
+
+	t, err := p.peek()
+	if err != nil {
+		return err
+	}
+	if t.GetLexeme() == "if" {
+		p.lex()
+		p.pushTrace(fmt.Sprintf("Key %s", t))
+		p.popTrace()
+
+		elseIf := NewNodeIf()
+		elseIf.pos = p.tokPos
+		if err := p.NodeIf(elseIf); err != nil {
+			return err
+		}
+		elseIf.endPos = p.tokEnd
+		nodeIf.AddElseIf(elseIf)
+
+		return nil
+	}
 
 	t, isLCurl, err := p.match(fxlex.TokLCurl)
 	if err != nil {
 		return err
 	} else if !isLCurl {
-		p.errorf("%s:%d: syntax error: if (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntil(fxlex.TokRCurl)
+		p.errorf(CSyntax, "if (bad statement)")
+		err = p.skipUntil(fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -995,6 +1415,7 @@ func (p *Parser) Else(nodeIf *NodeIf) error {
 	}
 
 	nodeIf.bodyElse = NewBody()
+	nodeIf.bodyElse.pos = p.tokPos
 	if err = p.Body(nodeIf.bodyElse); err != nil {
 		return err
 	}
@@ -1003,9 +1424,88 @@ func (p *Parser) Else(nodeIf *NodeIf) error {
 	if err != nil {
 		return err
 	} else if !isRCurl {
-		p.errorf("%s:%d: syntax error: if (bad statement)",
-			p.l.GetFilename(), p.l.GetLineNumber())
-		err = p.l.SkipUntilAndLex(fxlex.TokRCurl)
+		p.errorf(CSyntax, "if (bad statement)")
+		err = p.skipUntilAndLex(fxlex.TokRCurl)
+		if err != nil {
+			return err
+		}
+	} else {
+		p.pushTrace(fmt.Sprintf("%s", t))
+		p.popTrace()
+	}
+	nodeIf.bodyElse.endPos = p.tokEnd
+
+	return err
+}
+
+// <WHILE> ::= '(' <EXPR> ')' '{' <BODY> '}'
+func (p *Parser) While(while *While) error {
+	p.pushTrace("While")
+	defer p.popTrace()
+
+	t, isLPar, err := p.match(fxlex.TokLPar)
+	if err != nil {
+		return err
+	} else if !isLPar {
+		p.errorf(CSyntax, "while (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl, fxlex.TokRPar)
+		if err != nil {
+			return err
+		}
+	} else {
+		p.pushTrace(fmt.Sprintf("%s", t))
+		p.popTrace()
+	}
+
+	e, err := p.Expr(defRbp - 1)
+	if err != nil {
+		return err
+	}
+
+	while.AddCond(e)
+
+	t, isRPar, err := p.match(fxlex.TokRPar)
+	if err != nil {
+		return err
+	} else if !isRPar {
+		p.errorf(CSyntax, "while (bad statement)")
+		err = p.skipUntil(fxlex.TokLCurl, fxlex.TokRCurl)
+		if err != nil {
+			return err
+		}
+	} else {
+		p.pushTrace(fmt.Sprintf("%s", t))
+		p.popTrace()
+	}
+
+	t, isLCurl, err := p.match(fxlex.TokLCurl)
+	if err != nil {
+		return err
+	} else if !isLCurl {
+		p.errorf(CSyntax, "while (bad statement)")
+		err = p.skipUntil(fxlex.TokRCurl)
+		if err != nil {
+			return err
+		}
+	} else {
+		p.pushTrace(fmt.Sprintf("%s", t))
+		p.popTrace()
+	}
+
+	while.body.pos = p.tokPos
+	p.loops++
+	err = p.Body(while.body)
+	p.loops--
+	if err != nil {
+		return err
+	}
+
+	t, isRCurl, err := p.match(fxlex.TokRCurl)
+	if err != nil {
+		return err
+	} else if !isRCurl {
+		p.errorf(CSyntax, "while (bad statement)")
+		err = p.skipUntilAndLex(fxlex.TokRCurl)
 		if err != nil {
 			return err
 		}
@@ -1013,6 +1513,7 @@ func (p *Parser) Else(nodeIf *NodeIf) error {
 		p.pushTrace(fmt.Sprintf("%s", t))
 		p.popTrace()
 	}
+	while.body.endPos = p.tokEnd
 
 	return err
 }
@@ -2,8 +2,17 @@ package fxparse_test
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
 	"fxlex"
 	. "fxparse"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -17,6 +26,7 @@ var exampleFile = `//basic types bool, int (64 bits), Coord(int x, int y)
 //operators of bool are | & ! ^
 //precedence is like in C, with ** having the
 //same precedence as sizeof (not present in fx)
+//and | & ^ binding tighter than comparisons
 
 //builtins
 //circle(p, 2, 0x1100001f);
@@ -46,7 +56,7 @@ func main(){
   k = 2;
   px = 4;
   py = 45;
-  if(k > 3 | True) {
+  if((k > 3) | True) {
     circle(px, py, 2, 0x1100001f);
   } else {
     line(px, py);
@@ -85,3 +95,1098 @@ func TestParse(t *testing.T) {
 		t.Errorf("TestParse failed")
 	}
 }
+
+func TestParseProgram(t *testing.T) {
+	p := newTestParser(t, exampleFile)
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram failed: %s", err)
+	}
+
+	envs, err := NewEnv()
+	if err != nil {
+		t.Fatalf("NewEnv failed: %s", err)
+	}
+	if err := prog.Interp(envs); err != nil {
+		t.Errorf("Interp failed: %s", err)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	text := `func line(int x, int y) {
+	int k;
+	k = x / y;
+}
+
+func main() {
+	line(1, 0);
+}
+`
+	p := newTestParser(t, text)
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram failed: %s", err)
+	}
+	envs, _ := NewEnv()
+
+	err = prog.Interp(envs)
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected a *RuntimeError, got %v", err)
+	}
+	want := "test:3: division by zero in line() called from main()"
+	if rerr.Error() != want {
+		t.Errorf("got %q, want %q", rerr, want)
+	}
+	if len(rerr.Stack) != 2 || rerr.Stack[1].Pos.Line != 7 {
+		t.Errorf("bad stack %v", rerr.Stack)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	text := "func f(int x, flt y) {\n}\n\nfunc main() {\n}\n"
+	p := newTestParser(t, text)
+
+	_, err := p.ParseProgram()
+	diags, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList, got %v", err)
+	}
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d: %s", len(diags), diags)
+	}
+	d := diags[0]
+	if d.File != "test" || d.Line != 1 || d.Code != CUndefined || d.Severity != SevError {
+		t.Errorf("unexpected diagnostic %s", d)
+	}
+}
+
+func TestParseBadInput(t *testing.T) {
+	tests := []string{
+		"func main() {\n\tint k;\n\tk = 2",
+		"func main() {\n\tfoo(1);\n\tbar(2);\n}\n",
+		"func main() {\n\tint k;\n\tk = (2 + 3;\n}\n",
+		"func main(\n",
+		"func main() {\n\tcircle(1, 2, 3, 4)\n}\n",
+		"func main() {\n\telse {\n\t}\n}\n",
+	}
+
+	for _, text := range tests {
+		for _, max := range []int{1, 0} {
+			p := newTestParser(t, text)
+			p.SetMaxErrors(max)
+
+			_, err := p.ParseProgram()
+			diags, ok := err.(ErrorList)
+			if !ok {
+				t.Errorf("expected an ErrorList for %q, got %v", text, err)
+				continue
+			}
+			for _, d := range diags {
+				if d.File != "test" || d.Line == 0 {
+					t.Errorf("diagnostic without a place for %q: %s", text, d)
+				}
+			}
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	text := "func main() {\n\tint k;\n\tk = 3 % (k - k);\n\tfoo(k);\n}\n"
+	p := newTestParser(t, text)
+	p.SetSource([]byte(text))
+
+	_, err := p.ParseProgram()
+	diags, ok := err.(ErrorList)
+	if !ok || len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", err)
+	}
+	if d := diags[0]; d.Line != 4 || d.Column != 2 {
+		t.Errorf("diagnostic at %d:%d, want 4:2", d.Line, d.Column)
+	}
+
+	text = "func main() {\n\tint k;\n\tk = 1;\n\tk = 3 % (k - k);\n}\n"
+	p = newTestParser(t, text)
+	p.SetSource([]byte(text))
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram failed: %s", err)
+	}
+	if prog.Pos().Line != 1 || prog.Pos().Col != 1 || prog.End().Offset != len(text) {
+		t.Errorf("bad program span %s-%s", prog.Pos(), prog.End())
+	}
+	envs, _ := NewEnv()
+	err = prog.Interp(envs)
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Pos.String() != "test:4:6" {
+		t.Errorf("expected a runtime error at test:4:6, got %v", err)
+	}
+}
+
+func TestSourceParser(t *testing.T) {
+	text := "func main() {\n\tint k;\n\tk = 1;\n\tk = 3 % (k - k);\n}\n"
+	p, err := NewSourceParser("test", []byte(text))
+	if err != nil {
+		t.Fatalf("NewSourceParser failed: %s", err)
+	}
+	err = p.Parse()
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Pos.String() != "test:4:6" {
+		t.Errorf("expected a runtime error at test:4:6, got %v", err)
+	}
+
+	// Tokens skipped recovering from an error are still placed, so
+	// the ones after them keep their columns.
+	text = "func main() {\n\tfoo(1, (2));\n\tint k;\n\tk = // (3\n\t\tbar;\n}\n"
+	p, _ = NewSourceParser("test", []byte(text))
+	p.SetMaxErrors(0)
+	_, err = p.ParseProgram()
+	diags, ok := err.(ErrorList)
+	if !ok || len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", err)
+	}
+	if d := diags[1]; d.Line != 5 || d.Column != 3 {
+		t.Errorf("diagnostic %s at %d:%d, want 5:3", d, d.Line, d.Column)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	p := newTestParser(t, exampleFile)
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram failed: %s", err)
+	}
+
+	calls := map[string]int{}
+	Inspect(prog, func(n Node) bool {
+		if call, ok := n.(*Call); ok {
+			calls[call.Func().Name()]++
+		}
+		return true
+	})
+
+	want := map[string]int{"circle": 2, "line": 4, "rect": 1}
+	for name, n := range want {
+		if calls[name] != n {
+			t.Errorf("found %d calls to %s, want %d", calls[name], name, n)
+		}
+	}
+}
+
+func formatSource(t *testing.T, text string) string {
+	p := newTestParser(t, text)
+	p.SetSource([]byte(text))
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram failed: %s", err)
+	}
+
+	var b strings.Builder
+	if err := Fprint(&b, prog); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+
+	return b.String()
+}
+
+func TestFprint(t *testing.T) {
+	text := `func main(){
+int a;   int b;
+  a = ((1 + 2)) * 3;
+ b = 1 - (2 - 3) - 4;
+
+  b = (a ** 2) ** 3 ** -(a + b);
+  if(!(a < b) & (-a) * b > 0){ circle(a,b,(a),1); }else{b=-(a*b);}
+}
+`
+	want := `func main() {
+	int a;
+	int b;
+	a = (1 + 2) * 3;
+	b = 1 - (2 - 3) - 4;
+
+	b = (a ** 2) ** 3 ** -(a + b);
+	if (!(a < b) & (-a) * b > 0) {
+		circle(a, b, a, 1);
+	} else {
+		b = -a * b;
+	}
+}
+`
+	got := formatSource(t, text)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if again := formatSource(t, got); again != got {
+		t.Errorf("formatting is not idempotent, got\n%s", again)
+	}
+}
+
+func TestFprintComments(t *testing.T) {
+	got := formatSource(t, exampleFile)
+
+	for _, line := range strings.Split(exampleFile, "\n") {
+		i := strings.Index(line, "//")
+		if i < 0 {
+			continue
+		}
+		if !strings.Contains(got, line[i:]) {
+			t.Errorf("comment %q lost", line[i:])
+		}
+	}
+	if !strings.Contains(got, "\titer (i := 0, x, 1) { //declares") {
+		t.Errorf("line comment not kept on its statement:\n%s", got)
+	}
+	if again := formatSource(t, got); again != got {
+		t.Errorf("formatting is not idempotent, got\n%s", again)
+	}
+}
+
+func TestFprintElseComments(t *testing.T) {
+	text := `func main() {
+	int k;
+	k = 1;
+	if (k == 0) {
+		k = 2;
+	} // before else if
+	else if (k == 1) { // else if
+		// in else if
+		k = 3;
+	}
+	// before else
+	else { // else
+		k = 4;
+	}
+}
+`
+	want := `func main() {
+	int k;
+	k = 1;
+	if (k == 0) {
+		k = 2;
+	} else if (k == 1) { // before else if // else if
+		// in else if
+		k = 3;
+	} else { // before else // else
+		k = 4;
+	}
+}
+`
+	got := formatSource(t, text)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if again := formatSource(t, got); again != got {
+		t.Errorf("formatting is not idempotent, got\n%s", again)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	text := `func g(int x, bool b) {
+}
+
+func f(int x, bool b) {
+	int k;
+	bool ok;
+	k = 3;
+	if (k + 3) {
+		ok = k;
+	}
+	iter (i := 0, True, 1) {
+		g(b, x < k);
+	}
+	ok = !(x < k) & b;
+}
+
+func main() {
+	circle(True, 2, 3, 4);
+}
+`
+	p := newTestParser(t, text)
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("ParseProgram failed: %s", err)
+	}
+	var b strings.Builder
+	if err := Fprint(&b, prog); err != nil {
+		t.Errorf("Fprint failed: %s", err)
+	}
+
+	diags := Check(prog)
+	envs, _ := NewEnv()
+	r := &recorder{}
+	if err := prog.Render(envs, r); err == nil || err.Error() != diags.Error() {
+		t.Errorf("got %v rendering, want %v", err, diags)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("drew %q despite type errors", r.calls)
+	}
+
+	lines := []int{8, 9, 11, 12, 18}
+	if len(diags) != len(lines) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(lines), len(diags), diags)
+	}
+	for i, d := range diags {
+		if d.Code != CType || d.Line != lines[i] {
+			t.Errorf("unexpected diagnostic %s", d)
+		}
+	}
+}
+
+func runSource(t *testing.T, text string) (out string, err error) {
+	p := newTestParser(t, text)
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		return "", err
+	}
+	envs, _ := NewEnv()
+
+	var b strings.Builder
+	err = prog.Render(envs, NewTextRenderer(&b))
+
+	return b.String(), err
+}
+
+func TestCoord(t *testing.T) {
+	text := `func dot(Coord p, int r) {
+	Coord q;
+	q = p;
+	circle(q, r, [q.y, 1].x);
+	rect(q.x, [0x46, 4].y, 2, 0);
+}
+
+func main() {
+	dot([3, 4], 2);
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if want := "circle 3 4 2 4 \nrect 3 4 2 0 \n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+}
+
+func TestCoordArith(t *testing.T) {
+	text := `func line(Coord d) {
+	iter (i := 1, 3, 1) {
+		circle(d * i - [1, 1], 2, 0x1f);
+	}
+}
+
+func main() {
+	Coord p;
+	p = -[4, 2] / 2 + [1, 1];
+	line(p);
+	if ((p == [-1, 0]) & ([1, 2] != 2 * [1, 1])) {
+		rect(p * 3, 0, 0);
+	}
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle -2 -1 2 31 \ncircle -3 -1 2 31 \nrect -3 0 0 0 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+
+	_, err = runSource(t, "func main() {\n\tCoord p;\n\tp = [1, 2] * [3, 4];\n}\n")
+	if diags, ok := err.(ErrorList); !ok || len(diags) != 1 || diags[0].Code != CType {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+func TestValue(t *testing.T) {
+	vals := map[string]Value{
+		"-3":     IntVal(-3),
+		"True":   BoolVal(true),
+		"[1, 2]": CoordVal(Coord{1, 2}),
+	}
+	for want, v := range vals {
+		if v.String() != want {
+			t.Errorf("got %s, want %s", v, want)
+		}
+	}
+
+	c := IntVal(0x32ff8000).Color()
+	if want := (Color{T: 50, R: 0xff, G: 0x80, B: 0}); c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestEquality(t *testing.T) {
+	text := `func main() {
+	int k;
+	bool b;
+	k = 2;
+	b = (k + 1 == 3) & (k != 3);
+	if ((b == True) & (!(k == 2) == False) & ([k, 1] != [2, 2])) {
+		circle(k, k, k, k);
+	}
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if want := "circle 2 2 2 2 \n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+}
+
+func TestSpacedOps(t *testing.T) {
+	tests := []string{"k = = 2", "k ! = 2", "k < < 2", "k > > 2", "k =\n= 2"}
+	for _, expr := range tests {
+		text := "func main() {\n\tint k;\n\tbool b;\n\tb = " + expr + ";\n}\n"
+		p, _ := NewSourceParser("test", []byte(text))
+
+		_, err := p.ParseProgram()
+		diags, ok := err.(ErrorList)
+		if !ok || len(diags) == 0 || diags[0].Code != CSyntax {
+			t.Errorf("%q: expected a syntax error, got %v", expr, err)
+		}
+	}
+
+	text := "func main() {\n\tint k;\n\tbool b;\n\tb = k == 2 | k != 2 | k << 1 > k >> 1;\n}\n"
+	p, _ := NewSourceParser("test", []byte(text))
+	if _, err := p.ParseProgram(); err != nil {
+		t.Errorf("ParseProgram failed: %s", err)
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	text := `func main() {
+	int n;
+	n = 0;
+	if ((n > 0) & (10 / n > 2)) {
+		circle(1, 1, 1, 1);
+	}
+	if ((n == 0) | (10 / n > 2)) {
+		circle(2, 2, 2, 2);
+	}
+	if ((n == 0) & (10 / n > 2)) {
+		circle(3, 3, 3, 3);
+	}
+}
+`
+	out, err := runSource(t, text)
+	if want := "circle 2 2 2 2 \n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	rErr, ok := err.(*RuntimeError)
+	if !ok || rErr.Msg != "division by zero" || rErr.Pos.Line != 10 {
+		t.Errorf("expected division by zero at line 10, got %v", err)
+	}
+}
+
+func TestIntArith(t *testing.T) {
+	text := `func main() {
+	circle(3 ** 39, 2 ** 62, 7 % -3, -7 % 3);
+	circle((-2) ** 63 / 2, 0 ** 0, 0x7fffffff % 0x10000, 1);
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle 4052555153018976267 4611686018427387904 1 -1 \n" +
+		"circle -4611686018427387904 1 65535 1 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	errs := map[string]string{
+		"2 ** 63":  "integer overflow in 2 ** 63",
+		"3 ** -1":  "negative exponent -1",
+		"10 ** 19": "integer overflow in 10 ** 19",
+	}
+	for expr, want := range errs {
+		_, err := runSource(t, "func main() {\n\tcircle("+expr+", 0, 0, 0);\n}\n")
+		if rErr, ok := err.(*RuntimeError); !ok || rErr.Msg != want {
+			t.Errorf("%s: got %v, want %s", expr, err, want)
+		}
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	text := `func main() {
+	int col;
+	col = 50 << 24 | 0xff << 16 | 0x80;
+	circle(col >> 24, col >> 16 & 0xff, col & 0xff ^ 0x81, ~col & 0xff);
+	if ((col & 0xff == 0x80) & !((1 < 2) ^ True)) {
+		rect(-8 >> 1, 1 << 62 >> 61, 0, col);
+	}
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle 50 255 1 127 \nrect -4 2 0 855572608 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+
+	// & binds tighter than ^, and ^ than |, all of them tighter than
+	// comparisons, on either side.
+	text = `func main() {
+	int c;
+	bool b;
+	c = 0x1234;
+	circle(c >> 8 & 0xff | 0x100, c & 0xf ^ 0x3 | 0x8, c & 0xff, 0);
+	b = 0x34 == c & 0xff;
+	if (b & (0x30 < c & 0xff) & (c & 0xff == 0x34) & (c == c | 0x4)) {
+		rect(c & 1 ^ 1, 0, 0, 0);
+	}
+}
+`
+	out, err = runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want = "circle 274 15 52 0 \nrect 1 0 0 0 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+}
+
+func TestReturn(t *testing.T) {
+	text := `func int clamp(int x, int lo, int hi) {
+	if (x < lo) {
+		return lo;
+	}
+	if (x > hi) {
+		return hi;
+	}
+	return x;
+}
+
+func Coord mid(Coord a, Coord b) {
+	return (a + b) / 2;
+}
+
+func dots(int n) {
+	iter (i := 0, 10, 1) {
+		if (i == n) {
+			return;
+		}
+		circle(mid([0, 0], [i, 2 * i]), clamp(i * 40, 0, 100), 1);
+	}
+}
+
+func main() {
+	dots(3);
+	rect(clamp(-5, 0, 100) + 1, 2, clamp(clamp(7, 0, 5), 1, 3), 0);
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle 0 0 0 1 \ncircle 0 1 40 1 \ncircle 1 2 80 1 \nrect 1 2 3 0 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+
+	bad := `func int f(int x) {
+	return;
+}
+
+func g() {
+	return 1;
+}
+
+func main() {
+	circle(g(), 1, f(True), 1);
+}
+`
+	_, err = runSource(t, bad)
+	diags, ok := err.(ErrorList)
+	if !ok || len(diags) != 4 {
+		t.Fatalf("expected 4 diagnostics, got %v", err)
+	}
+	for i, line := range []int{2, 6, 10, 10} {
+		if diags[i].Line != line {
+			t.Errorf("unexpected diagnostic %s", diags[i])
+		}
+	}
+}
+
+func TestElseIf(t *testing.T) {
+	text := `func sign(int x) {
+	if (x < 0) {
+		circle(x, 0, 1, 1);
+	} else if (x == 0) {
+		circle(x, 0, 2, 2);
+	} else if (x < 10) {
+		circle(x, 0, 3, 3);
+	} else {
+		circle(x, 0, 4, 4);
+	}
+}
+
+func main() {
+	sign(-1);
+	sign(0);
+	sign(5);
+	sign(10);
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle -1 0 1 1 \ncircle 0 0 2 2 \ncircle 5 0 3 3 \ncircle 10 0 4 4 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+}
+
+func TestWhile(t *testing.T) {
+	text := `func main() {
+	int r;
+	r = 64;
+	while (True) {
+		r = r / 2;
+		if (r % 4 != 0) {
+			break;
+		}
+		iter (i := 0, 4, 1) {
+			if (i == 1) {
+				continue;
+			}
+			if (i == 3) {
+				break;
+			}
+			circle(r, i, 1, 1);
+		}
+	}
+	rect(r, 0, 0, 0);
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle 32 0 1 1 \ncircle 32 2 1 1 \ncircle 16 0 1 1 \ncircle 16 2 1 1 \n" +
+		"circle 8 0 1 1 \ncircle 8 2 1 1 \ncircle 4 0 1 1 \ncircle 4 2 1 1 \nrect 2 0 0 0 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+
+	_, err = runSource(t, "func main() {\n\tbreak;\n}\n")
+	if diags, ok := err.(ErrorList); !ok || len(diags) != 1 || diags[0].Line != 2 {
+		t.Errorf("expected a break outside a loop, got %v", err)
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	tests := []string{
+		"func main() {\n\tint while;\n}\n",
+		"func main() {\n\tbool return;\n}\n",
+		"func f(int break) {\n}\n\nfunc main() {\n}\n",
+		"func f(int x, Coord continue) {\n}\n\nfunc main() {\n}\n",
+		"func main() {\n\titer (while := 0, 3, 1) {\n\t}\n}\n",
+		"func return() {\n}\n\nfunc main() {\n}\n",
+	}
+	for _, text := range tests {
+		p := newTestParser(t, text)
+		_, err := p.ParseProgram()
+		diags, ok := err.(ErrorList)
+		if !ok || len(diags) == 0 || diags[0].Code != CSyntax || !strings.Contains(diags[0].Msg, "reserved") {
+			t.Errorf("%q: expected a reserved name error, got %v", text, err)
+		}
+	}
+
+	p := newTestParser(t, "func main() {\n}\n")
+	noop := func(ctx *Ctx, args []Value) error { return nil }
+	if err := p.RegisterBuiltin("while", nil, noop); err == nil {
+		t.Errorf("registered a builtin named while")
+	}
+	if err := p.RegisterBuiltin("star", []Param{{"break", TInt}}, noop); err == nil {
+		t.Errorf("registered a builtin with a parameter named break")
+	}
+}
+
+func TestIterSteps(t *testing.T) {
+	text := `func main() {
+	iter (i := 3, 0, -1) {
+		circle(i, 0, 0, 0);
+	}
+	iter (i := 0, <= 4, 2) {
+		circle(i, 1, 0, 0);
+	}
+	iter (i := 2, >= -2, -2) {
+		circle(i, 2, 0, 0);
+	}
+	iter (i := 0, 0, 1) {
+		circle(i, 3, 0, 0);
+	}
+}
+`
+	out, err := runSource(t, text)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	want := "circle 3 0 0 0 \ncircle 2 0 0 0 \ncircle 1 0 0 0 \n" +
+		"circle 0 1 0 0 \ncircle 2 1 0 0 \ncircle 4 1 0 0 \n" +
+		"circle 2 2 0 0 \ncircle 0 2 0 0 \ncircle -2 2 0 0 \n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if got := formatSource(t, text); got != text {
+		t.Errorf("got\n%s\nwant\n%s", got, text)
+	}
+
+	errs := map[string]string{
+		"0, 10, 0":     "iter step is 0",
+		"0, <= 10, -1": "iter step -1 does not go towards <= end",
+	}
+	for rng, want := range errs {
+		_, err := runSource(t, "func main() {\n\titer (i := "+rng+") {\n\t}\n}\n")
+		if rErr, ok := err.(*RuntimeError); !ok || rErr.Msg != want {
+			t.Errorf("%s: got %v, want %s", rng, err, want)
+		}
+	}
+
+	out, err = runSource(t, "func main() {\n\titer (i := 0x7ffffffffffffffe, <= 0x7fffffffffffffff, 1) {\n\t\tcircle(i, 0, 0, 0);\n\t}\n}\n")
+	if err != nil || strings.Count(out, "circle") != 2 {
+		t.Errorf("iter up to the largest int: got %q, %v", out, err)
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	text := `func main() {
+	star([1, 2], 5, True);
+	star([3, 4], 0, False);
+}
+`
+	p := newTestParser(t, text)
+	var got []string
+	params := []Param{{"at", TCoord}, {"n", TInt}, {"filled", TBool}}
+	err := p.RegisterBuiltin("star", params, func(ctx *Ctx, args []Value) error {
+		if args[1].Int() == 0 {
+			return errors.New("star without points")
+		}
+		got = append(got, fmt.Sprint(args))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	envs, _ := NewEnv()
+	err = prog.Interp(envs)
+	if want := "test:3: star(): star without points in main()"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+	if len(got) != 1 || got[0] != "[[1, 2] 5 True]" {
+		t.Errorf("got calls %q", got)
+	}
+}
+
+func TestRegisterBadBuiltin(t *testing.T) {
+	noop := func(ctx *Ctx, args []Value) error { return nil }
+	tests := []struct {
+		params []Param
+		fn     BuiltinFunc
+	}{
+		{[]Param{{"n", TInt}}, nil},
+		{[]Param{{"x", 99}}, noop},
+		{[]Param{{"x", TUndef}}, noop},
+		{[]Param{{"at", TCoord}, {"x", -1}}, noop},
+	}
+	for _, test := range tests {
+		p := newTestParser(t, "func main() {\n}\n")
+		if err := p.RegisterBuiltin("star", test.params, test.fn); err == nil {
+			t.Errorf("registered star with %v, nil func %v", test.params, test.fn == nil)
+		}
+	}
+}
+
+type recorder struct {
+	calls []string
+}
+
+func (r *recorder) Begin() error {
+	r.calls = append(r.calls, "begin")
+	return nil
+}
+
+func (r *recorder) Circle(at Coord, rad int64, col int64) error {
+	c := DecodeColor(col)
+	r.calls = append(r.calls, fmt.Sprintf("circle %v %d %+v", at, rad, c))
+	return nil
+}
+
+func (r *recorder) Rect(at Coord, angle int64, col int64) error {
+	r.calls = append(r.calls, fmt.Sprintf("rect %v %d %d", at, angle, col))
+	return nil
+}
+
+func (r *recorder) End() error {
+	r.calls = append(r.calls, "end")
+	return nil
+}
+
+func TestRender(t *testing.T) {
+	p := newTestParser(t, exampleFile)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	envs, _ := NewEnv()
+	r := &recorder{}
+	if err := prog.Render(envs, r); err != nil {
+		t.Fatalf("render failed: %s", err)
+	}
+
+	n := len(r.calls)
+	if n < 2 || r.calls[0] != "begin" || r.calls[n-1] != "end" {
+		t.Fatalf("calls not between begin and end: %q", r.calls)
+	}
+	want := "circle {4 45} 2 {T:17 R:0 G:0 B:31}"
+	if r.calls[1] != want {
+		t.Errorf("got %q, want %q", r.calls[1], want)
+	}
+
+	p = newTestParser(t, "func main() {\n\tcircle(1, 2, 3, 0);\n\tcircle(1, 2, -3, 0);\n}\n")
+	prog, err = p.ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	envs, _ = NewEnv()
+	r = &recorder{}
+	err = prog.Render(envs, r)
+	if rErr, ok := err.(*RuntimeError); !ok || rErr.Msg != "circle(): negative radius -3" || rErr.Pos.Line != 3 {
+		t.Errorf("expected a negative radius at line 3, got %v", err)
+	}
+	if len(r.calls) != 2 {
+		t.Errorf("got calls %q, want begin and one circle", r.calls)
+	}
+}
+
+func TestBuiltinPoint(t *testing.T) {
+	out, err := runSource(t, "func main() {\n\tcircle(1, 2, 3, 4);\n\tcircle([1, 2], 3, 4);\n\trect([5, 6], 45, 7);\n}\n")
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if want := "circle 1 2 3 4 \ncircle 1 2 3 4 \nrect 5 6 45 7 \n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	// Only the point may be given as two ints, or as a Coord.
+	tests := []string{
+		"rect(1, 2, [45, 7]);",
+		"circle(1, [3, 4], 0);",
+		"circle([1, 2], [3, 4]);",
+		"rect(1, [2, 3], 4, 5);",
+	}
+	for _, stm := range tests {
+		_, err := runSource(t, "func main() {\n\t"+stm+"\n}\n")
+		if _, ok := err.(ErrorList); !ok {
+			t.Errorf("%s: expected an ErrorList, got %v", stm, err)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	text := `func main() {
+	circle([4, 45], 2, 0x1100001f);
+	rect([20, 30], 45, 0xff8000);
+	rect([0, 0], 0, 0x64ffffff);
+}
+`
+	p := newTestParser(t, text)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	envs, _ := NewEnv()
+	var b strings.Builder
+	if err := prog.Render(envs, NewSVGRenderer(&b, 64, 48)); err != nil {
+		t.Fatalf("render failed: %s", err)
+	}
+
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="64" height="48" viewBox="0 0 64 48">
+	<circle cx="4" cy="45" r="2" fill="#00001f" fill-opacity="0.83"/>
+	<rect x="15" y="25" width="10" height="10" transform="rotate(45 20 30)" fill="#ff8000"/>
+	<rect x="-5" y="-5" width="10" height="10" transform="rotate(0 0 0)" fill="#ffffff" fill-opacity="0"/>
+</svg>
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRaster(t *testing.T) {
+	text := `func main() {
+	circle([4, 4], 2, 0xff0000);
+	rect([10, 10], 0, 0x32000000);
+	rect([10, 10], 45, 0x640000ff);
+}
+`
+	p := newTestParser(t, text)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	envs, _ := NewEnv()
+	var b bytes.Buffer
+	rr := NewRasterRenderer(&b, 16, 16, color.White)
+	if err := prog.Render(envs, rr); err != nil {
+		t.Fatalf("render failed: %s", err)
+	}
+
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{4, 4, color.RGBA{0xff, 0x00, 0x00, 0xff}},
+		{4, 7, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{10, 10, color.RGBA{0x7f, 0x7f, 0x7f, 0xff}},
+		{15, 15, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, test := range tests {
+		if got := rr.Image().RGBAAt(test.x, test.y); got != test.want {
+			t.Errorf("pixel %d, %d: got %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if got := img.Bounds(); got != image.Rect(0, 0, 16, 16) {
+		t.Errorf("got bounds %v, want %v", got, image.Rect(0, 0, 16, 16))
+	}
+	rr = NewRasterRenderer(nil, 4, 4, nil)
+	if err := rr.Begin(); err != nil {
+		t.Fatalf("Begin failed: %s", err)
+	}
+	if got := rr.Image().RGBAAt(0, 0); got != (color.RGBA{}) {
+		t.Errorf("nil background: got %v, want transparent", got)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func renderPNG(t *testing.T, text string, samples int) ([]byte, *RasterRenderer) {
+	t.Helper()
+	p := newTestParser(t, text)
+	prog, err := p.ParseProgram()
+	if err != nil {
+		t.Fatalf("parse failed: %s", err)
+	}
+	envs, _ := NewEnv()
+	var b bytes.Buffer
+	rr := NewRasterRenderer(&b, 32, 32, color.White)
+	rr.SetSamples(samples)
+	if err := prog.Render(envs, rr); err != nil {
+		t.Fatalf("render failed: %s", err)
+	}
+	return b.Bytes(), rr
+}
+
+func TestRasterAntialias(t *testing.T) {
+	text := `func main() {
+	circle([12, 12], 8, 0xff0000);
+	rect([24, 22], 30, 0x0000ff);
+	rect([16, 16], 0, 0x32008000);
+}
+`
+	golden := filepath.Join("testdata", "antialias.png")
+	got, rr := renderPNG(t, text, 4)
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	again, _ := renderPNG(t, text, 4)
+	if !bytes.Equal(got, again) {
+		t.Errorf("rendering twice gave different images")
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("decode %s failed: %s", golden, err)
+	}
+	b := img.Bounds()
+	if b != rr.Image().Bounds() {
+		t.Fatalf("got bounds %v, want %v", rr.Image().Bounds(), b)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if g := rr.Image().RGBAAt(x, y); g != w {
+				t.Errorf("pixel %d, %d: got %v, want %v", x, y, g, w)
+			}
+		}
+	}
+
+	// The circle edge crosses pixel 6, 6: it is blended with the
+	// background, while without anti-aliasing it is fully painted.
+	_, aliased := renderPNG(t, text, 1)
+	if g := aliased.Image().RGBAAt(6, 6); g != (color.RGBA{0xff, 0x00, 0x00, 0xff}) {
+		t.Errorf("aliased edge: got %v, want red", g)
+	}
+	if g := rr.Image().RGBAAt(6, 6); g.G == 0x00 || g.G == 0xff || g.G != g.B {
+		t.Errorf("anti-aliased edge: got %v, want a blend of red and white", g)
+	}
+	for _, pt := range []image.Point{{12, 12}, {4, 12}, {5, 5}} {
+		if g, w := rr.Image().RGBAAt(pt.X, pt.Y), aliased.Image().RGBAAt(pt.X, pt.Y); g != w {
+			t.Errorf("pixel %v away from edges: got %v, want %v", pt, g, w)
+		}
+	}
+}
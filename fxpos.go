@@ -0,0 +1,110 @@
+package fxparse
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Pos is a place in the source. The lexer only reports lines, so Col
+// (in bytes, starting at 1) and Offset are only known if the parser was
+// given the source text, see NewSourceParser and SetSource. Otherwise
+// Col is 0.
+type Pos struct {
+	File   string
+	Line   int
+	Col    int
+	Offset int
+}
+
+func (pos Pos) String() string {
+	if pos.Col > 0 {
+		return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Col)
+	}
+	return fmt.Sprintf("%s:%d", pos.File, pos.Line)
+}
+
+// srcText is the source being parsed. The tokens handed by the lexer
+// are placed in it one after the other to find their columns.
+type srcText struct {
+	file  string
+	src   []byte
+	lines []int
+	off   int
+}
+
+func newSrcText(file string, src []byte) (st *srcText) {
+	st = &srcText{file: file, src: src}
+	st.lines = append(st.lines, 0)
+	for i, c := range src {
+		if c == '\n' {
+			st.lines = append(st.lines, i+1)
+		}
+	}
+
+	return st
+}
+
+func (st *srcText) pos(off int) Pos {
+	line := sort.Search(len(st.lines), func(i int) bool {
+		return st.lines[i] > off
+	})
+	return Pos{st.file, line, off - st.lines[line-1] + 1, off}
+}
+
+// skipBlanks returns the first offset from off which is neither
+// a blank nor part of a comment.
+func (st *srcText) skipBlanks(off int) int {
+	for off < len(st.src) {
+		switch c := st.src[off]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			off++
+		case c == '/' && off+1 < len(st.src) && st.src[off+1] == '/':
+			for off < len(st.src) && st.src[off] != '\n' {
+				off++
+			}
+		default:
+			return off
+		}
+	}
+
+	return off
+}
+
+// find returns the offset of the next token, whose text is lexeme.
+// It must be right after the last token placed: if it is not, the
+// token cannot be placed.
+func (st *srcText) find(lexeme string) (off int, ok bool) {
+	off = st.skipBlanks(st.off)
+	if !bytes.HasPrefix(st.src[off:], []byte(lexeme)) {
+		return 0, false
+	}
+
+	return off, true
+}
+
+// place returns where the next token starts and ends, and moves past it.
+func (st *srcText) place(lexeme string) (pos, end Pos, ok bool) {
+	off, ok := st.find(lexeme)
+	if !ok {
+		return Pos{}, Pos{}, false
+	}
+	st.off = off + len(lexeme)
+
+	return st.pos(off), st.pos(st.off), true
+}
+
+// peek is like place, but does not move past the token.
+func (st *srcText) peek(lexeme string) (pos Pos, ok bool) {
+	off, ok := st.find(lexeme)
+	if !ok {
+		return Pos{}, false
+	}
+
+	return st.pos(off), true
+}
+
+func (st *srcText) eof() Pos {
+	st.off = len(st.src)
+	return st.pos(st.off)
+}
@@ -1,22 +1,69 @@
 package fxparse
 
 import (
-	"errors"
 	"fmt"
 	"fxlex"
 	"os"
 	"strings"
 )
 
+// Operators not given by a single token type of the lexer, numbered
+// out of the range of its token types: those made of two tokens, and
+// the int forms of &, | and ^, which Check gives to those on ints.
+const (
+	OpEq = -100 - iota
+	OpNeq
+	OpShl
+	OpShr
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+)
+
+// glueTab holds, for each token starting an operator made of two,
+// the token ending it and the operator made.
+var glueTab = map[int]struct{ next, op int }{
+	fxlex.Assignation: {fxlex.Assignation, OpEq},
+	fxlex.TokNeg:      {fxlex.Assignation, OpNeq},
+	'<':               {'<', OpShl},
+	'>':               {'>', OpShr},
+}
+
+// bitTab holds the int form of each operator also taking bools.
+var bitTab = map[int]int{
+	fxlex.TokAnd: OpBitAnd,
+	fxlex.TokOr:  OpBitOr,
+	fxlex.TokXor: OpBitXor,
+}
+
+var opNames = map[int]string{
+	OpEq:     "==",
+	OpNeq:    "!=",
+	OpShl:    "<<",
+	OpShr:    ">>",
+	OpBitAnd: "&",
+	OpBitOr:  "|",
+	OpBitXor: "^",
+}
+
+// precTab holds the binding power of each operator. The int forms of
+// &, | and ^, only told apart by Check, bind as the bool ones.
 var precTab = map[rune]int{
 	')':          1,
-	'|':          10,
-	'&':          20,
-	'^':          30,
+	OpEq:         35,
+	OpNeq:        35,
 	'<':          40,
 	'>':          40,
 	fxlex.TokGTE: 40,
 	fxlex.TokLTE: 40,
+	'|':          41,
+	OpBitOr:      41,
+	'^':          42,
+	OpBitXor:     42,
+	'&':          43,
+	OpBitAnd:     43,
+	OpShl:        45,
+	OpShr:        45,
 	'+':          50,
 	'-':          50,
 	'*':          60,
@@ -24,7 +71,9 @@ var precTab = map[rune]int{
 	'%':          60,
 	fxlex.TokPow: 70,
 	'!':          70,
+	'~':          70,
 	'(':          80,
+	'.':          90,
 }
 
 var leftTab = map[rune]bool{
@@ -36,6 +85,7 @@ var unaryTab = map[rune]bool{
 	'-': true,
 	'(': true,
 	'!': true,
+	'~': true,
 }
 
 //no left context, null-denotation: nud
@@ -51,34 +101,141 @@ func (p *Parser) Nud(tok fxlex.Token) (expr *Expr, err error) {
 		if _, isClosed, err := p.match(fxlex.TokRPar); err != nil {
 			return nil, err
 		} else if !isClosed {
-			return nil, errors.New("unmatched parenthesis")
+			p.errorf(CSyntax, "unmatched parenthesis")
+			return nil, errBail
 		}
 		return expr, nil
 	}
 	expr = NewExpr(tok)
+	expr.pos, expr.endPos = p.tokPos, p.tokEnd
+	if tok.GetTokType() == '[' { //Coord literal
+		return p.CoordLit(expr)
+	}
 	rbp = bindPow(tok)
 	rTok := rune(tok.GetTokType())
 	if rbp != defRbp { //regular unary operators
 		if !unaryTab[rTok] {
-			errs := fmt.Sprintf("%s  is not unary", tok.GetType())
-			return nil, errors.New(errs)
+			p.errorf(CSyntax, "%s is not unary", tok.GetType())
+			return nil, errBail
 		}
 		rExpr, err = p.Expr(rbp)
+		if err != nil {
+			return nil, err
+		}
 		if rExpr == nil {
-			return nil, errors.New("unary operator without operand")
+			p.errorf(CSyntax, "unary operator without operand")
+			return nil, errBail
 		}
 		expr.ERight = rExpr
+		expr.endPos = rExpr.endPos
+		return expr, nil
+	}
+	switch tok.GetTokType() { //operands
+	case fxlex.TokIntLit, fxlex.TokBoolLit:
+	case fxlex.TokID:
+		expr.sym = p.stkEnv.GetSym(tok.GetLexeme())
+		if expr.sym == nil {
+			p.errorfAt(expr.pos, CUndefined, "symbol %s not found", tok.GetLexeme())
+		} else if expr.sym.SymType() == "SFunc" {
+			if next, err := p.peek(); err != nil {
+				return nil, err
+			} else if next.GetTokType() != fxlex.TokLPar {
+				p.errorfAt(expr.pos, CSyntax, "macro %s used without calling it", tok.GetLexeme())
+				return nil, errBail
+			}
+		} else if expr.sym.SymType() != "SVar" {
+			p.errorfAt(expr.pos, CSyntax, "%s is not a variable", tok.GetLexeme())
+			expr.sym = nil
+		}
+	default:
+		p.errorfAt(expr.pos, CSyntax, "unexpected %s in expression", tok.GetLexeme())
+		return nil, errBail
+	}
+	return expr, nil
+}
+
+// <COORD_LIT> ::= '[' <EXPR> ',' <EXPR> ']'
+func (p *Parser) CoordLit(expr *Expr) (*Expr, error) {
+	x, err := p.Expr(defRbp - 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, isComma, err := p.match(fxlex.TokComma); err != nil {
+		return nil, err
+	} else if !isComma || x == nil {
+		p.errorf(CSyntax, "bad Coord literal")
+		return nil, errBail
+	}
+	y, err := p.Expr(defRbp - 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, isClosed, err := p.match(']'); err != nil {
+		return nil, err
+	} else if !isClosed || y == nil {
+		p.errorf(CSyntax, "bad Coord literal")
+		return nil, errBail
 	}
+	expr.ELeft = x
+	expr.ERight = y
+	expr.endPos = p.tokEnd
+	return expr, nil
+}
+
+// <CALL_EXPR> ::= macro_id '(' ')' |
+//                 macro_id '(' <ARGS_LIST> ')'
+func (p *Parser) CallExpr(expr *Expr) (*Expr, error) {
+	left := expr.ELeft
+	if left.tok.GetTokType() != fxlex.TokID || left.sym == nil || left.sym.SymType() != "SFunc" {
+		p.errorfAt(left.pos, CSyntax, "called expression is not a macro")
+		return nil, errBail
+	}
+	expr.call = NewCall()
+	expr.call.AddFunc(left.sym)
+	expr.call.pos = left.pos
+
+	if _, isRPar, err := p.match(fxlex.TokRPar); err != nil {
+		return nil, err
+	} else if !isRPar {
+		if err := p.ArgsList(expr.call); err != nil {
+			return nil, err
+		}
+		if _, isRPar, err := p.match(fxlex.TokRPar); err != nil {
+			return nil, err
+		} else if !isRPar {
+			p.errorf(CSyntax, "unmatched parenthesis")
+			return nil, errBail
+		}
+	}
+	expr.call.endPos = p.tokEnd
+	expr.endPos = p.tokEnd
 	return expr, nil
 }
 
 //left context, left-denotation: led
-func (p *Parser) Led(left *Expr, tok fxlex.Token) (expr *Expr, err error) {
+func (p *Parser) Led(left *Expr, tok fxlex.Token, op int) (expr *Expr, err error) {
 	var rbp int
 	expr = NewExpr(tok)
+	expr.op = op
+	expr.pos = left.pos
 	expr.ELeft = left
-	rbp = bindPow(tok)
-	if isleft := leftTab[rune(tok.GetTokType())]; isleft {
+	if tok.GetTokType() == '.' { //component of a Coord
+		tSel, isID, err := p.match(fxlex.TokID)
+		if err != nil {
+			return nil, err
+		} else if !isID || (tSel.GetLexeme() != "x" && tSel.GetLexeme() != "y") {
+			p.errorf(CSyntax, "expected x or y after .")
+			return nil, errBail
+		}
+		expr.sel = tSel.GetLexeme()
+		expr.endPos = p.tokEnd
+		return expr, nil
+	}
+	if tok.GetTokType() == fxlex.TokLPar { //call of a macro
+		return p.CallExpr(expr)
+	}
+	rbp = opPow(op)
+	if isleft := leftTab[rune(op)]; isleft {
 		rbp -= 1
 	}
 	p.dPrintf("Led: %d, {{%s}} %s \n", rbp, left, tok)
@@ -87,22 +244,61 @@ func (p *Parser) Led(left *Expr, tok fxlex.Token) (expr *Expr, err error) {
 		return nil, err
 	}
 	if rExpr == nil {
-		errs := fmt.Sprintf("missing operand for %s\n", tok.GetType())
-		return nil, errors.New(errs)
+		p.errorf(CSyntax, "missing operand for %s", opString(expr))
+		return nil, errBail
 	}
 	expr.ERight = rExpr
+	expr.endPos = rExpr.endPos
 	return expr, nil
 }
 
 const defRbp = 0
 
 func bindPow(tok fxlex.Token) int {
-	if rbp, ok := precTab[rune(tok.GetTokType())]; ok {
+	return opPow(tok.GetTokType())
+}
+
+func opPow(op int) int {
+	if rbp, ok := precTab[rune(op)]; ok {
 		return rbp
 	}
 	return defRbp
 }
 
+// opString returns the text of the operator of e.
+func opString(e *Expr) string {
+	if name, ok := opNames[e.op]; ok {
+		return name
+	}
+	return e.tok.GetLexeme()
+}
+
+// peekOp returns the operator starting with tok, the next token,
+// made of it alone or glued to the one after. Tokens are only glued
+// if nothing separates them, which can only be told with the source:
+// without it, they are glued anyway.
+func (p *Parser) peekOp(tok fxlex.Token) (op int, err error) {
+	op = tok.GetTokType()
+	glue, ok := glueTab[op]
+	if !ok {
+		return op, nil
+	}
+	next, err := p.peek2()
+	if err != nil {
+		return op, err
+	}
+	if next.GetTokType() != glue.next {
+		return op, nil
+	}
+	if p.src != nil {
+		pos, ok := p.src.peek(next.GetLexeme())
+		if !ok || pos.Offset != p.ahead.end.Offset {
+			return op, nil
+		}
+	}
+	return glue.op, nil
+}
+
 func (p *Parser) Expr(rbp int) (expr *Expr, err error) {
 	var left *Expr
 
@@ -110,7 +306,7 @@ func (p *Parser) Expr(rbp int) (expr *Expr, err error) {
 	p.pushTrace(s)
 	defer p.popTrace()
 
-	tok, err := p.l.Peek()
+	tok, err := p.peek()
 	if err != nil {
 		return expr, err
 	}
@@ -119,26 +315,33 @@ func (p *Parser) Expr(rbp int) (expr *Expr, err error) {
 	if tok.GetTokType() == fxlex.RuneEOF {
 		return expr, nil
 	}
-	p.l.Lex() //already peeked
+	p.lex() //already peeked
 	if left, err = p.Nud(tok); err != nil {
 		return nil, err
 	}
 	expr = left
 	for {
-		tok, err := p.l.Peek()
+		tok, err := p.peek()
 		if err != nil {
 			return expr, err
 		}
-		if tok.GetTokType() == fxlex.RuneEOF || tok.GetTokType() == fxlex.TokRPar || tok.GetTokType() == fxlex.TokComma || tok.GetTokType() == fxlex.Semicolon {
+		if tok.GetTokType() == fxlex.RuneEOF || tok.GetTokType() == fxlex.TokRPar || tok.GetTokType() == ']' || tok.GetTokType() == fxlex.TokComma || tok.GetTokType() == fxlex.Semicolon {
 			return expr, nil
 		}
-		if bindPow(tok) <= rbp {
-			p.dPrintf("Not enough binding: %d <= %d, %s\n", bindPow(tok), rbp, tok)
+		op, err := p.peekOp(tok)
+		if err != nil {
+			return expr, err
+		}
+		if opPow(op) <= rbp {
+			p.dPrintf("Not enough binding: %d <= %d, %s\n", opPow(op), rbp, tok)
 			return left, nil
 		}
-		p.l.Lex() //already peeked
+		p.lex() //already peeked
+		if op != tok.GetTokType() {
+			p.lex() //second token of the operator
+		}
 		p.dPrintf("expr: led Lex: %s", tok)
-		if left, err = p.Led(left, tok); err != nil {
+		if left, err = p.Led(left, tok, op); err != nil {
 			return expr, err
 		}
 		expr = left
@@ -0,0 +1,129 @@
+package fxparse
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+// RasterRenderer draws the shapes on an RGBA image of the given size,
+// blending each over what is already drawn by its opacity. The origin
+// is at the top left corner, with y growing downwards. Pixel (x, y)
+// covers the square from x, y to x+1, y+1, and is painted if its
+// center falls in a shape. With anti-aliasing, see SetSamples, it is
+// instead painted in proportion to how much of it the shape covers.
+type RasterRenderer struct {
+	w       io.Writer
+	img     *image.RGBA
+	bg      color.Color
+	samples int
+}
+
+// NewRasterRenderer returns a renderer painting the canvas with bg at
+// Begin, transparent if bg is nil, and writing it to w as a PNG image
+// at End if w is not nil.
+func NewRasterRenderer(w io.Writer, width, height int, bg color.Color) *RasterRenderer {
+	if bg == nil {
+		bg = color.Transparent
+	}
+	return &RasterRenderer{
+		w:   w,
+		img: image.NewRGBA(image.Rect(0, 0, width, height)),
+		bg:  bg,
+	}
+}
+
+// SetSamples makes the renderer anti-alias edges by testing an n by n
+// grid of points evenly spread over each pixel, instead of its center,
+// and scaling the opacity of a shape by the fraction of them inside it.
+// If n <= 1, pixels are either painted or not.
+func (rr *RasterRenderer) SetSamples(n int) {
+	rr.samples = n
+}
+
+// Image returns the canvas.
+func (rr *RasterRenderer) Image() *image.RGBA {
+	return rr.img
+}
+
+func (rr *RasterRenderer) Begin() error {
+	draw.Draw(rr.img, rr.img.Bounds(), image.NewUniform(rr.bg), image.Point{}, draw.Src)
+	return nil
+}
+
+func (rr *RasterRenderer) Circle(at Coord, r int64, col int64) error {
+	cx, cy, fr := float64(at.X), float64(at.Y), float64(r)
+	inside := func(x, y float64) bool {
+		dx, dy := x-cx, y-cy
+		return dx*dx+dy*dy <= fr*fr
+	}
+	rr.fill(at, fr, inside, DecodeColor(col))
+	return nil
+}
+
+func (rr *RasterRenderer) Rect(at Coord, angle int64, col int64) error {
+	cx, cy := float64(at.X), float64(at.Y)
+	sin, cos := math.Sincos(float64(angle) * math.Pi / 180)
+	half := float64(RectSize) / 2
+	inside := func(x, y float64) bool {
+		dx, dy := x-cx, y-cy
+		u := dx*cos + dy*sin
+		v := -dx*sin + dy*cos
+		return math.Abs(u) <= half && math.Abs(v) <= half
+	}
+	rr.fill(at, half*math.Sqrt2, inside, DecodeColor(col))
+	return nil
+}
+
+func (rr *RasterRenderer) End() error {
+	if rr.w == nil {
+		return nil
+	}
+	return png.Encode(rr.w, rr.img)
+}
+
+// fill blends c over the pixels with samples inside a shape reaching
+// at most reach from at.
+func (rr *RasterRenderer) fill(at Coord, reach float64, inside func(x, y float64) bool, c Color) {
+	b := image.Rect(
+		int(math.Floor(float64(at.X)-reach)), int(math.Floor(float64(at.Y)-reach)),
+		int(math.Ceil(float64(at.X)+reach))+1, int(math.Ceil(float64(at.Y)+reach))+1,
+	).Intersect(rr.img.Bounds())
+
+	n := rr.samples
+	if n < 1 {
+		n = 1
+	}
+	alpha := c.Opacity() * 0xff / float64(n*n)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			k := 0
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					sx := float64(x) + (float64(j)+0.5)/float64(n)
+					sy := float64(y) + (float64(i)+0.5)/float64(n)
+					if inside(sx, sy) {
+						k++
+					}
+				}
+			}
+			if k > 0 {
+				rr.blend(x, y, c, uint32(math.Round(alpha*float64(k))))
+			}
+		}
+	}
+}
+
+// blend composites c with the given alpha, from 0 to 0xff, over
+// pixel x, y.
+func (rr *RasterRenderer) blend(x, y int, c Color, alpha uint32) {
+	i := rr.img.PixOffset(x, y)
+	pix := rr.img.Pix[i : i+4 : i+4]
+	src := [4]uint32{uint32(c.R), uint32(c.G), uint32(c.B), 0xff}
+	for j := range pix {
+		pix[j] = uint8((src[j]*alpha + uint32(pix[j])*(0xff-alpha) + 0x7f) / 0xff)
+	}
+}
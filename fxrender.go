@@ -0,0 +1,59 @@
+package fxparse
+
+import (
+	"fmt"
+	"io"
+)
+
+// RectSize is the side of the squares drawn by rect, which are
+// centered on their point and rotated around it.
+const RectSize = 10
+
+// Renderer draws the shapes of a running program. Begin is called
+// before the program runs and End once it finishes without errors.
+// Colors are packed as for the builtins, see DecodeColor. Radii are
+// never negative.
+type Renderer interface {
+	Begin() error
+	Circle(at Coord, r int64, col int64) error
+	Rect(at Coord, angle int64, col int64) error
+	End() error
+}
+
+// DecodeColor unpacks col, a color given to a builtin as 0xTTRRGGBB.
+func DecodeColor(col int64) Color {
+	return Color{
+		T: uint8(col >> 24),
+		R: uint8(col >> 16),
+		G: uint8(col >> 8),
+		B: uint8(col),
+	}
+}
+
+// TextRenderer writes a line of text for each shape: the builtin
+// drawing it followed by its arguments.
+type TextRenderer struct {
+	w io.Writer
+}
+
+func NewTextRenderer(w io.Writer) *TextRenderer {
+	return &TextRenderer{w: w}
+}
+
+func (tr *TextRenderer) Begin() error {
+	return nil
+}
+
+func (tr *TextRenderer) Circle(at Coord, r int64, col int64) error {
+	_, err := fmt.Fprintf(tr.w, "circle %d %d %d %d \n", at.X, at.Y, r, col)
+	return err
+}
+
+func (tr *TextRenderer) Rect(at Coord, angle int64, col int64) error {
+	_, err := fmt.Fprintf(tr.w, "rect %d %d %d %d \n", at.X, at.Y, angle, col)
+	return err
+}
+
+func (tr *TextRenderer) End() error {
+	return nil
+}
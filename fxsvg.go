@@ -0,0 +1,52 @@
+package fxparse
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// SVGRenderer writes the shapes as an SVG image of the given size,
+// with the origin at the top left corner and y growing downwards.
+type SVGRenderer struct {
+	w      io.Writer
+	width  int
+	height int
+}
+
+func NewSVGRenderer(w io.Writer, width, height int) *SVGRenderer {
+	return &SVGRenderer{w: w, width: width, height: height}
+}
+
+func (sr *SVGRenderer) Begin() error {
+	_, err := fmt.Fprintf(sr.w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		sr.width, sr.height, sr.width, sr.height)
+	return err
+}
+
+func (sr *SVGRenderer) Circle(at Coord, r int64, col int64) error {
+	_, err := fmt.Fprintf(sr.w, "\t<circle cx=\"%d\" cy=\"%d\" r=\"%d\"%s/>\n", at.X, at.Y, r, svgFill(col))
+	return err
+}
+
+func (sr *SVGRenderer) Rect(at Coord, angle int64, col int64) error {
+	x, y := at.X-RectSize/2, at.Y-RectSize/2
+	_, err := fmt.Fprintf(sr.w, "\t<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" transform=\"rotate(%d %d %d)\"%s/>\n",
+		x, y, RectSize, RectSize, angle, at.X, at.Y, svgFill(col))
+	return err
+}
+
+func (sr *SVGRenderer) End() error {
+	_, err := fmt.Fprintf(sr.w, "</svg>\n")
+	return err
+}
+
+// svgFill returns the attributes filling a shape with col.
+func svgFill(col int64) string {
+	c := DecodeColor(col)
+	fill := fmt.Sprintf(" fill=\"#%02x%02x%02x\"", c.R, c.G, c.B)
+	if c.T != 0 {
+		fill += fmt.Sprintf(" fill-opacity=\"%s\"", strconv.FormatFloat(c.Opacity(), 'g', -1, 64))
+	}
+	return fill
+}
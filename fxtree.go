@@ -12,8 +12,11 @@ import (
 const nullString = "nil"
 
 type Prog struct {
-	funcs []*fxsym.Sym
-	depth int
+	funcs       []*fxsym.Sym
+	endComments []*Comment
+	pos         Pos
+	endPos      Pos
+	depth       int
 }
 
 func NewProg() (prog *Prog) {
@@ -29,6 +32,14 @@ func (p *Prog) AddFunc(f *fxsym.Sym) {
 	}
 }
 
+func (p *Prog) Pos() Pos {
+	return p.pos
+}
+
+func (p *Prog) End() Pos {
+	return p.endPos
+}
+
 func (p *Prog) String() string {
 	if p == nil {
 		return nullString
@@ -46,31 +57,68 @@ func (p *Prog) String() string {
 	return output
 }
 
-func (prog *Prog) Interp(envs *fxsym.StkEnv) {
+// Interp runs the program in envs, which must come from NewEnv,
+// writing its shapes as text to the standard output.
+func (prog *Prog) Interp(envs *fxsym.StkEnv) error {
+	return prog.Render(envs, NewTextRenderer(os.Stdout))
+}
+
+// Render type checks the program and runs it in envs, which must come
+// from NewEnv, drawing its shapes with r. Type errors are returned as
+// an ErrorList before anything is drawn, and errors found while running
+// as a *RuntimeError.
+func (prog *Prog) Render(envs *fxsym.StkEnv, r Renderer) error {
+	if err := Check(prog).Err(); err != nil {
+		return err
+	}
+	if err := r.Begin(); err != nil {
+		return err
+	}
+	if err := prog.run(envs, r); err != nil {
+		return err
+	}
+
+	return r.End()
+}
+
+func (prog *Prog) run(envs *fxsym.StkEnv, r Renderer) error {
 	envs.DPrintf("Prog\n")
 
+	ctx := newCtx(envs, r)
 	for _, f := range prog.funcs {
 		if f == nil {
 			continue
 		}
 
 		if f.Name() == "main" {
-			f.Content().(*Func).Interp(envs)
+			ctx.pushFrame(f.Name(), f.Content().(*Func).pos)
+			err := f.Content().(*Func).Interp(ctx)
+			ctx.popFrame()
+			if err != nil {
+				return err
+			}
 		} else {
 			fSym, err := envs.NewSym(f.Name(), fxsym.SFunc)
 			if err != nil {
-				panic("bad func definition")
+				return ctx.errorf(f.Content().(*Func).pos, "bad func definition %s", f.Name())
 			}
 
 			fSym.AddContent(f.Content().(*Func))
 		}
 	}
+
+	return nil
 }
 
 type Func struct {
-	head  *Head
-	body  *Body
-	depth int
+	head    *Head
+	body    *Body
+	fn      BuiltinFunc
+	doc     []*Comment
+	comment *Comment
+	pos     Pos
+	endPos  Pos
+	depth   int
 }
 
 func NewFunc() (f *Func) {
@@ -81,6 +129,14 @@ func NewFunc() (f *Func) {
 	return f
 }
 
+func (f *Func) Pos() Pos {
+	return f.pos
+}
+
+func (f *Func) End() Pos {
+	return f.endPos
+}
+
 func (f *Func) String() string {
 	if f == nil {
 		return nullString
@@ -98,20 +154,28 @@ func (f *Func) String() string {
 	return output
 }
 
-func (f *Func) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("Func\n")
+func (f *Func) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Func\n")
+
+	if f.body == nil {
+		return nil
+	}
 
-	envs.PushEnv()
-	f.body.PushVars(envs)
-	if f.body != nil {
-		f.body.Interp(envs)
+	ctx.envs.PushEnv()
+	defer ctx.envs.PopEnv()
+	if err := f.body.PushVars(ctx); err != nil {
+		return err
 	}
-	envs.PopEnv()
+
+	return f.body.Interp(ctx)
 }
 
 type Head struct {
 	id     string
 	params []*fxsym.Sym
+	result int
+	pos    Pos
+	endPos Pos
 	depth  int
 }
 
@@ -127,6 +191,14 @@ func (h *Head) AddParam(param *fxsym.Sym) {
 	}
 }
 
+func (h *Head) Pos() Pos {
+	return h.pos
+}
+
+func (h *Head) End() Pos {
+	return h.endPos
+}
+
 func (h *Head) String() string {
 	if h == nil {
 		return nullString
@@ -134,6 +206,9 @@ func (h *Head) String() string {
 
 	tabs := strings.Repeat("\t", h.depth)
 	output := fmt.Sprintf("%s%p HEAD(%s)", tabs, h, h.id)
+	if h.result != TUndef {
+		output += fmt.Sprintf(" %s", Types[h.result])
+	}
 	for _, value := range h.params {
 		value.SetDepth(h.depth + 1)
 		output += fmt.Sprintf("\n%s", value)
@@ -143,8 +218,11 @@ func (h *Head) String() string {
 }
 
 type Body struct {
-	stms  []*Statement
-	depth int
+	stms        []*Statement
+	endComments []*Comment
+	pos         Pos
+	endPos      Pos
+	depth       int
 }
 
 func NewBody() (body *Body) {
@@ -160,27 +238,44 @@ func (b *Body) AddStm(stm *Statement) {
 	}
 }
 
-func (b *Body) PushVars(envs *fxsym.StkEnv) {
+func (b *Body) PushVars(ctx *Ctx) error {
 	for _, stm := range b.stms {
 		if stm.decl != nil {
-			envs.DPrintf("PUSHING VAR %s\n", stm.decl.Name())
-			s, err := envs.NewSymWithShadowing(stm.decl.Name(), fxsym.SVar)
+			ctx.envs.DPrintf("PUSHING VAR %s\n", stm.decl.Name())
+			s, err := ctx.envs.NewSymWithShadowing(stm.decl.Name(), fxsym.SVar)
 			if err != nil {
-				panic(err)
+				return ctx.errorf(stm.pos, "%s (%s)", err, stm.decl.Name())
 			}
 			s.SetType(stm.decl.Type())
 		}
 	}
+
+	return nil
 }
 
-func (b *Body) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("Body\n")
+func (b *Body) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Body\n")
 	for _, stm := range b.stms {
 		if stm == nil {
 			continue
 		}
-		stm.Interp(envs)
+		if err := stm.Interp(ctx); err != nil {
+			return err
+		}
+		if ctx.jump != jumpNone {
+			break
+		}
 	}
+
+	return nil
+}
+
+func (b *Body) Pos() Pos {
+	return b.pos
+}
+
+func (b *Body) End() Pos {
+	return b.endPos
 }
 
 func (b *Body) String() string {
@@ -200,13 +295,20 @@ func (b *Body) String() string {
 
 type Statement struct {
 	// One of these
-	call   *Call
-	iter   *Iter
-	body   *Body
-	decl   *fxsym.Sym
-	asign  *Asign
-	nodeIf *NodeIf
-	depth  int
+	call    *Call
+	iter    *Iter
+	body    *Body
+	decl    *fxsym.Sym
+	asign   *Asign
+	nodeIf  *NodeIf
+	while   *While
+	branch  *Branch
+	ret     *Return
+	doc     []*Comment
+	comment *Comment
+	pos     Pos
+	endPos  Pos
+	depth   int
 }
 
 func NewStatement() (stm *Statement) {
@@ -217,6 +319,9 @@ func NewStatement() (stm *Statement) {
 	stm.decl = nil
 	stm.asign = nil
 	stm.nodeIf = nil
+	stm.while = nil
+	stm.branch = nil
+	stm.ret = nil
 
 	return stm
 }
@@ -257,24 +362,56 @@ func (stm *Statement) AddNodeIf(nodeIf *NodeIf) {
 	}
 }
 
-func (stm *Statement) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("Statement\n")
+func (stm *Statement) AddWhile(while *While) {
+	if while != nil {
+		stm.while = while
+	}
+}
+
+func (stm *Statement) AddBranch(branch *Branch) {
+	if branch != nil {
+		stm.branch = branch
+	}
+}
+
+func (stm *Statement) AddReturn(ret *Return) {
+	if ret != nil {
+		stm.ret = ret
+	}
+}
+
+func (stm *Statement) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Statement\n")
 
 	if stm.call != nil {
-		stm.call.Interp(envs)
+		return stm.call.Interp(ctx)
 	} else if stm.iter != nil {
-		stm.iter.Interp(envs)
+		return stm.iter.Interp(ctx)
 	} else if stm.body != nil {
-		stm.body.Interp(envs)
+		return stm.body.Interp(ctx)
 	} else if stm.decl != nil {
-		return
+		return nil
 	} else if stm.asign != nil {
-		stm.asign.Interp(envs)
+		return stm.asign.Interp(ctx)
 	} else if stm.nodeIf != nil {
-		stm.nodeIf.Interp(envs)
-	} else {
-		panic("empty statement")
+		return stm.nodeIf.Interp(ctx)
+	} else if stm.while != nil {
+		return stm.while.Interp(ctx)
+	} else if stm.branch != nil {
+		return stm.branch.Interp(ctx)
+	} else if stm.ret != nil {
+		return stm.ret.Interp(ctx)
 	}
+
+	return ctx.errorf(stm.pos, "empty statement")
+}
+
+func (stm *Statement) Pos() Pos {
+	return stm.pos
+}
+
+func (stm *Statement) End() Pos {
+	return stm.endPos
 }
 
 func (stm *Statement) String() string {
@@ -300,15 +437,26 @@ func (stm *Statement) String() string {
 	} else if stm.nodeIf != nil {
 		stm.nodeIf.depth = stm.depth
 		return fmt.Sprintf("%s", stm.nodeIf)
+	} else if stm.while != nil {
+		stm.while.depth = stm.depth
+		return fmt.Sprintf("%s", stm.while)
+	} else if stm.branch != nil {
+		stm.branch.depth = stm.depth
+		return fmt.Sprintf("%s", stm.branch)
+	} else if stm.ret != nil {
+		stm.ret.depth = stm.depth
+		return fmt.Sprintf("%s", stm.ret)
 	}
 
 	return nullString
 }
 
 type Call struct {
-	f     *fxsym.Sym
-	args  []*Expr
-	depth int
+	f      *fxsym.Sym
+	args   []*Expr
+	pos    Pos
+	endPos Pos
+	depth  int
 }
 
 func NewCall() (call *Call) {
@@ -331,6 +479,14 @@ func (c *Call) AddArg(arg *Expr) {
 	}
 }
 
+func (c *Call) Pos() Pos {
+	return c.pos
+}
+
+func (c *Call) End() Pos {
+	return c.endPos
+}
+
 func (c *Call) String() string {
 	if c == nil {
 		return nullString
@@ -350,38 +506,103 @@ func (c *Call) String() string {
 	return output
 }
 
-func (call *Call) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("Func\n")
+func (call *Call) Interp(ctx *Ctx) error {
+	_, err := call.eval(ctx)
+	return err
+}
 
-	eS := *envs
-	if fSym, ok := eS[0][call.f.Name()]; ok {
-		f := fSym.Content().(*Func)
+// eval runs call and returns the value the macro returned, the zero
+// Value if it returns none.
+func (call *Call) eval(ctx *Ctx) (Value, error) {
+	ctx.envs.DPrintf("Func\n")
+
+	if f := call.f.Content().(*Func); f.fn != nil {
+		// Two int arguments stand for a Coord parameter of a builtin.
+		params := f.head.params
+		vals := make([]Value, len(call.args))
+		tps := make([]int, len(call.args))
+		for n, arg := range call.args {
+			v, err := arg.Eval(ctx)
+			if err != nil {
+				return Value{}, err
+			}
+			vals[n], tps[n] = v, v.tp
+		}
 
-		if len(f.head.params) != len(call.args) {
-			panic("Number of args error")
+		var args []Value
+		n := 0
+		for i, k := range builtinArgs(params, tps) {
+			for j := n; j < n+k && i < len(params); j++ {
+				want := params[i].Type()
+				if k == 2 {
+					want = TInt
+				}
+				if vals[j].tp != want {
+					return Value{}, ctx.errorf(call.args[j].pos, "argument %d of %s() is %s, want %s", j+1, f.head.id, Types[vals[j].tp], Types[want])
+				}
+			}
+			v := vals[n]
+			if k == 2 {
+				v = CoordVal(Coord{vals[n].i, vals[n+1].i})
+			}
+			args = append(args, v)
+			n += k
 		}
 
-		args := ""
-		for _, arg := range call.args {
-			args += fmt.Sprintf("%d ", arg.Eval(envs))
+		if len(params) != len(args) {
+			return Value{}, ctx.errorf(call.pos, "bad number of args calling %s()", f.head.id)
 		}
 
-		fmt.Printf("%s %s\n", f.head.id, args)
+		if err := f.fn(ctx, args); err != nil {
+			if _, ok := err.(*RuntimeError); ok {
+				return Value{}, err
+			}
+			return Value{}, ctx.errorf(call.pos, "%s(): %s", f.head.id, err)
+		}
+		return Value{}, nil
 	} else {
-		fSym = envs.GetSym(call.f.Name())
+		fSym := ctx.envs.GetSym(call.f.Name())
+		if fSym == nil {
+			return Value{}, ctx.errorf(call.pos, "macro %s does not exist", call.f.Name())
+		}
 		f := fSym.Content().(*Func)
 
 		if len(f.head.params) != len(call.args) {
-			panic("Number of args error")
+			return Value{}, ctx.errorf(call.pos, "bad number of args calling %s()", f.head.id)
+		}
+
+		vals := make([]Value, len(call.args))
+		for i, arg := range call.args {
+			v, err := arg.Eval(ctx)
+			if err != nil {
+				return Value{}, err
+			}
+			if tp := f.head.params[i].Type(); v.tp != tp {
+				return Value{}, ctx.errorf(arg.pos, "argument %d of %s() is %s, want %s", i+1, f.head.id, Types[v.tp], Types[tp])
+			}
+			vals[i] = v
 		}
 
-		envs.PushEnv()
+		ctx.envs.PushEnv()
+		defer ctx.envs.PopEnv()
 		for i, param := range f.head.params {
-			sParam, _ := envs.NewSymWithShadowing(param.Name(), fxsym.SVar)
-			sParam.AddContent(call.args[i].Eval(envs))
+			sParam, _ := ctx.envs.NewSymWithShadowing(param.Name(), fxsym.SVar)
+			sParam.SetType(param.Type())
+			sParam.AddContent(vals[i])
+		}
+
+		ctx.pushFrame(f.head.id, call.pos)
+		defer ctx.popFrame()
+		if err := f.Interp(ctx); err != nil {
+			return Value{}, err
+		}
+		if ctx.jump != jumpReturn && f.head.result != TUndef {
+			return Value{}, ctx.errorf(f.endPos, "missing return at end of %s()", f.head.id)
 		}
-		f.Interp(envs)
-		envs.PopEnv()
+		ctx.jump = jumpNone
+		result := ctx.result
+		ctx.result = Value{}
+		return result, nil
 	}
 }
 
@@ -389,8 +610,11 @@ type Iter struct {
 	varControl *fxsym.Sym
 	start      *Expr
 	end        *Expr
+	incl       string
 	step       *Expr
 	body       *Body
+	pos        Pos
+	endPos     Pos
 	depth      int
 }
 
@@ -431,23 +655,69 @@ func (iter *Iter) AddBody(b *Body) {
 	}
 }
 
-func (iter *Iter) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("Iter\n")
+func (iter *Iter) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Iter\n")
+
+	start, err := iter.start.evalInt(ctx, "iter start")
+	if err != nil {
+		return err
+	}
+	end, err := iter.end.evalInt(ctx, "iter end")
+	if err != nil {
+		return err
+	}
+	step, err := iter.step.evalInt(ctx, "iter step")
+	if err != nil {
+		return err
+	}
+	if step == 0 {
+		return ctx.errorf(iter.step.pos, "iter step is 0")
+	}
+	if (iter.incl == "<=" && step < 0) || (iter.incl == ">=" && step > 0) {
+		return ctx.errorf(iter.step.pos, "iter step %d does not go towards %s end", step, iter.incl)
+	}
 
-	envs.PushEnv()
-	iter.body.PushVars(envs)
-	varControl, err := envs.NewSym(iter.varControl.Name(), fxsym.SVar)
+	ctx.envs.PushEnv()
+	defer ctx.envs.PopEnv()
+	if err := iter.body.PushVars(ctx); err != nil {
+		return err
+	}
+	varControl, err := ctx.envs.NewSym(iter.varControl.Name(), fxsym.SVar)
 	if err != nil {
-		panic("varControl failed")
+		return ctx.errorf(iter.pos, "%s (%s)", err, iter.varControl.Name())
+	}
+	varControl.SetType(TInt)
+	for i := start; iter.inRange(i, end, step); i += step {
+		varControl.AddContent(IntVal(i))
+		if err := iter.body.Interp(ctx); err != nil {
+			return err
+		}
+		if ctx.endLoop() {
+			break
+		}
+		if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+			break
+		}
 	}
-	start := iter.start.Eval(envs)
-	end := iter.end.Eval(envs)
-	step := iter.step.Eval(envs)
-	for i := start; i < end; i += step {
-		varControl.AddContent(i)
-		iter.body.Interp(envs)
+
+	return nil
+}
+
+// inRange reports whether i is before end, going by step, or at end
+// for an inclusive iter.
+func (iter *Iter) inRange(i, end, step int64) bool {
+	if step > 0 {
+		return i < end || (iter.incl != "" && i == end)
 	}
-	envs.PopEnv()
+	return i > end || (iter.incl != "" && i == end)
+}
+
+func (iter *Iter) Pos() Pos {
+	return iter.pos
+}
+
+func (iter *Iter) End() Pos {
+	return iter.endPos
 }
 
 func (iter *Iter) String() string {
@@ -456,7 +726,7 @@ func (iter *Iter) String() string {
 	}
 
 	tabs := strings.Repeat("\t", iter.depth)
-	output := fmt.Sprintf("%s%p ITER\n", tabs, iter)
+	output := fmt.Sprintf("%s%p ITER%s\n", tabs, iter, iter.incl)
 	// Control variable
 	iter.varControl.SetDepth(iter.depth + 1)
 	output += fmt.Sprintf("%s\n", iter.varControl)
@@ -477,9 +747,11 @@ func (iter *Iter) String() string {
 }
 
 type Asign struct {
-	sym   *fxsym.Sym
-	value *Expr
-	depth int
+	sym    *fxsym.Sym
+	value  *Expr
+	pos    Pos
+	endPos Pos
+	depth  int
 }
 
 func NewAsign() (asign *Asign) {
@@ -502,6 +774,14 @@ func (asign *Asign) AddValue(value *Expr) {
 	}
 }
 
+func (asign *Asign) Pos() Pos {
+	return asign.pos
+}
+
+func (asign *Asign) End() Pos {
+	return asign.endPos
+}
+
 func (asign *Asign) String() string {
 	if asign == nil {
 		return nullString
@@ -519,28 +799,48 @@ func (asign *Asign) String() string {
 	return output
 }
 
-func (asign *Asign) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("Asign\n")
+func (asign *Asign) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Asign\n")
 
-	valVar := asign.value.Eval(envs)
-	v := envs.GetSym(asign.sym.Name())
+	valVar, err := asign.value.Eval(ctx)
+	if err != nil {
+		return err
+	}
+	v := ctx.envs.GetSym(asign.sym.Name())
 	if v == nil {
-		panic("Symbol not defined")
+		return ctx.errorf(asign.pos, "symbol %s does not exist", asign.sym.Name())
+	}
+	if valVar.tp != v.Type() {
+		return ctx.errorf(asign.pos, "cannot assign %s to %s %s", Types[valVar.tp], Types[v.Type()], asign.sym.Name())
 	}
 	v.AddContent(valVar)
+
+	return nil
 }
 
+// NodeIf is an if, or an if chained after else. The comments of the
+// line of an else if ending the body before it are those of the chained
+// if, and those of the line of a final else are in elseDoc and
+// elseComment.
 type NodeIf struct {
-	cond     *Expr
-	body     *Body
-	bodyElse *Body
-	depth    int
+	cond        *Expr
+	body        *Body
+	elseIf      *NodeIf
+	bodyElse    *Body
+	pos         Pos
+	endPos      Pos
+	depth       int
+	doc         []*Comment
+	comment     *Comment
+	elseDoc     []*Comment
+	elseComment *Comment
 }
 
 func NewNodeIf() (nodeIf *NodeIf) {
 	nodeIf = &NodeIf{depth: 0}
 	nodeIf.cond = nil
 	nodeIf.body = NewBody()
+	nodeIf.elseIf = nil
 	nodeIf.bodyElse = nil
 
 	return nodeIf
@@ -558,12 +858,26 @@ func (nodeIf *NodeIf) AddBody(b *Body) {
 	}
 }
 
+func (nodeIf *NodeIf) AddElseIf(elseIf *NodeIf) {
+	if elseIf != nil {
+		nodeIf.elseIf = elseIf
+	}
+}
+
 func (nodeIf *NodeIf) AddBodyElse(b *Body) {
 	if b != nil {
 		nodeIf.bodyElse = b
 	}
 }
 
+func (nodeIf *NodeIf) Pos() Pos {
+	return nodeIf.pos
+}
+
+func (nodeIf *NodeIf) End() Pos {
+	return nodeIf.endPos
+}
+
 func (nodeIf *NodeIf) String() string {
 	if nodeIf == nil {
 		return nullString
@@ -577,6 +891,12 @@ func (nodeIf *NodeIf) String() string {
 	// Body
 	nodeIf.body.depth = nodeIf.depth + 1
 	output += fmt.Sprintf("%s", nodeIf.body)
+	// Else if
+	if nodeIf.elseIf != nil {
+		output += fmt.Sprintf("\n%s%p ELSE\n", tabs, nodeIf)
+		nodeIf.elseIf.depth = nodeIf.depth
+		output += fmt.Sprintf("%s", nodeIf.elseIf)
+	}
 	// Else
 	if nodeIf.bodyElse != nil {
 		output += fmt.Sprintf("\n%s%p ELSE\n", tabs, nodeIf)
@@ -588,27 +908,229 @@ func (nodeIf *NodeIf) String() string {
 	return output
 }
 
-func (nodeIf *NodeIf) Interp(envs *fxsym.StkEnv) {
-	envs.DPrintf("NodeIf\n")
+func (nodeIf *NodeIf) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("NodeIf\n")
+
+	cond, err := nodeIf.cond.evalBool(ctx, "if condition")
+	if err != nil {
+		return err
+	}
+
+	if cond {
+		return nodeIf.body.Interp(ctx)
+	} else if nodeIf.elseIf != nil {
+		return nodeIf.elseIf.Interp(ctx)
+	} else if nodeIf.bodyElse != nil {
+		return nodeIf.bodyElse.Interp(ctx)
+	}
+
+	return nil
+}
+
+type While struct {
+	cond   *Expr
+	body   *Body
+	pos    Pos
+	endPos Pos
+	depth  int
+}
+
+func NewWhile() (while *While) {
+	while = &While{depth: 0}
+	while.cond = nil
+	while.body = NewBody()
+
+	return while
+}
+
+func (while *While) AddCond(e *Expr) {
+	if e != nil {
+		while.cond = e
+	}
+}
+
+func (while *While) AddBody(b *Body) {
+	if b != nil {
+		while.body = b
+	}
+}
+
+func (while *While) Pos() Pos {
+	return while.pos
+}
 
-	if nodeIf.cond.Eval(envs) != 0 {
-		nodeIf.body.Interp(envs)
+func (while *While) End() Pos {
+	return while.endPos
+}
+
+func (while *While) String() string {
+	if while == nil {
+		return nullString
+	}
+
+	tabs := strings.Repeat("\t", while.depth)
+	output := fmt.Sprintf("%s%p WHILE\n", tabs, while)
+	// Condition
+	while.cond.depth = while.depth + 1
+	output += fmt.Sprintf("%s\n", while.cond)
+	// Body
+	while.body.depth = while.depth + 1
+	output += fmt.Sprintf("%s", while.body)
+
+	return output
+}
+
+func (while *While) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("While\n")
+
+	for {
+		cond, err := while.cond.evalBool(ctx, "while condition")
+		if err != nil {
+			return err
+		}
+		if !cond {
+			return nil
+		}
+
+		ctx.envs.PushEnv()
+		err = while.body.PushVars(ctx)
+		if err == nil {
+			err = while.body.Interp(ctx)
+		}
+		ctx.envs.PopEnv()
+		if err != nil {
+			return err
+		}
+		if ctx.endLoop() {
+			return nil
+		}
+	}
+}
+
+// Branch is a break or a continue statement.
+type Branch struct {
+	key    string
+	pos    Pos
+	endPos Pos
+	depth  int
+}
+
+func NewBranch(key string) (branch *Branch) {
+	return &Branch{key: key, depth: 0}
+}
+
+func (branch *Branch) Pos() Pos {
+	return branch.pos
+}
+
+func (branch *Branch) End() Pos {
+	return branch.endPos
+}
+
+func (branch *Branch) String() string {
+	if branch == nil {
+		return nullString
+	}
+
+	tabs := strings.Repeat("\t", branch.depth)
+	return fmt.Sprintf("%s%p %s", tabs, branch, strings.ToUpper(branch.key))
+}
+
+func (branch *Branch) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Branch\n")
+
+	if branch.key == "break" {
+		ctx.jump = jumpBreak
 	} else {
-		if nodeIf.bodyElse != nil {
-			nodeIf.bodyElse.Interp(envs)
+		ctx.jump = jumpContinue
+	}
+
+	return nil
+}
+
+type Return struct {
+	value  *Expr
+	pos    Pos
+	endPos Pos
+	depth  int
+}
+
+func NewReturn() (ret *Return) {
+	ret = &Return{depth: 0}
+	ret.value = nil
+
+	return ret
+}
+
+func (ret *Return) AddValue(value *Expr) {
+	if value != nil {
+		ret.value = value
+	}
+}
+
+func (ret *Return) Pos() Pos {
+	return ret.pos
+}
+
+func (ret *Return) End() Pos {
+	return ret.endPos
+}
+
+func (ret *Return) String() string {
+	if ret == nil {
+		return nullString
+	}
+
+	tabs := strings.Repeat("\t", ret.depth)
+	output := fmt.Sprintf("%s%p RETURN", tabs, ret)
+	// Value
+	if ret.value != nil {
+		ret.value.depth = ret.depth + 1
+		output += fmt.Sprintf("\n%s", ret.value)
+	}
+
+	return output
+}
+
+func (ret *Return) Interp(ctx *Ctx) error {
+	ctx.envs.DPrintf("Return\n")
+
+	if ret.value != nil {
+		v, err := ret.value.Eval(ctx)
+		if err != nil {
+			return err
 		}
+		ctx.result = v
 	}
+	ctx.jump = jumpReturn
+
+	return nil
 }
 
 type Expr struct {
 	tok    fxlex.Token
+	op     int
+	sym    *fxsym.Sym
+	sel    string
+	call   *Call
+	tp     int
 	ERight *Expr
 	ELeft  *Expr
+	pos    Pos
+	endPos Pos
 	depth  int
 }
 
 func NewExpr(tok fxlex.Token) (expr *Expr) {
-	return &Expr{tok: tok, depth: 0}
+	return &Expr{tok: tok, op: tok.GetTokType(), depth: 0}
+}
+
+func (e *Expr) Pos() Pos {
+	return e.pos
+}
+
+func (e *Expr) End() Pos {
+	return e.endPos
 }
 
 func (e *Expr) String() string {
@@ -617,90 +1139,277 @@ func (e *Expr) String() string {
 	}
 
 	tabs := strings.Repeat("\t", e.depth)
-	return fmt.Sprintf("%s%p EXPR[%s](%d) L->%p R->%p", tabs, e, e.tok.GetType(), e.tok.GetValue(), e.ELeft, e.ERight)
+	tp := e.tok.GetType()
+	if name, ok := opNames[e.op]; ok {
+		tp = name
+	}
+	return fmt.Sprintf("%s%p EXPR[%s](%d) L->%p R->%p", tabs, e, tp, e.tok.GetValue(), e.ELeft, e.ERight)
 }
 
-func (e *Expr) Eval(envs *fxsym.StkEnv) int64 {
+func (e *Expr) Eval(ctx *Ctx) (Value, error) {
 	if DebugParser {
 		fmt.Fprintf(os.Stderr, "%s\n", e)
 	}
-	rV := int64(0)
-	lV := int64(0)
 	if e == nil {
-		return 0
+		return Value{}, ctx.errorf(Pos{}, "missing expression")
 	}
-	if e.ERight != nil {
-		rV = e.ERight.Eval(envs)
+	tok := e.tok
+	switch e.op {
+	case fxlex.TokIntLit:
+		return IntVal(tok.GetValue()), nil
+	case fxlex.TokBoolLit:
+		return BoolVal(tok.GetValue() != 0), nil
+	case fxlex.TokID:
+		sym := ctx.envs.GetSym(tok.GetLexeme())
+		if sym == nil {
+			return Value{}, ctx.errorf(e.pos, "symbol %s does not exist", tok.GetLexeme())
+		}
+		v, ok := sym.Content().(Value)
+		if !ok {
+			return Value{}, ctx.errorf(e.pos, "%s used before being assigned", tok.GetLexeme())
+		}
+
+		return v, nil
+	case '[':
+		x, err := e.ELeft.evalInt(ctx, "x of Coord")
+		if err != nil {
+			return Value{}, err
+		}
+		y, err := e.ERight.evalInt(ctx, "y of Coord")
+		if err != nil {
+			return Value{}, err
+		}
+		return CoordVal(Coord{x, y}), nil
+	case '(':
+		v, err := e.call.eval(ctx)
+		if err != nil {
+			return Value{}, err
+		}
+		if v.tp == TUndef {
+			return Value{}, ctx.errorf(e.pos, "%s() returns no value", e.call.f.Name())
+		}
+		return v, nil
+	case '.':
+		v, err := e.ELeft.Eval(ctx)
+		if err != nil {
+			return Value{}, err
+		}
+		if v.tp != TCoord {
+			return Value{}, ctx.errorf(e.pos, "operand of .%s is %s, want Coord", e.sel, Types[v.tp])
+		}
+		if e.sel == "x" {
+			return IntVal(v.c.X), nil
+		}
+		return IntVal(v.c.Y), nil
 	}
-	if e.ELeft != nil {
-		lV = e.ELeft.Eval(envs)
+
+	if e.ERight == nil {
+		return Value{}, ctx.errorf(e.pos, "bad subtree %s", tok.GetLexeme())
 	}
-	tok := e.tok
-	switch tok.GetTokType() {
+	if e.ELeft == nil {
+		r, err := e.ERight.Eval(ctx)
+		if err != nil {
+			return Value{}, err
+		}
+		return e.evalUnary(ctx, r)
+	}
+
+	// Operands are evaluated left to right. The right operand of & and
+	// | is not evaluated when the left one decides the result, as in C.
+	l, err := e.ELeft.Eval(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+	if l.tp == TBool && (e.op == fxlex.TokAnd && !l.Bool() || e.op == fxlex.TokOr && l.Bool()) {
+		return l, nil
+	}
+	r, err := e.ERight.Eval(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return e.evalBinary(ctx, l, r)
+}
+
+// evalInt evaluates e, described by what, which must be an int.
+func (e *Expr) evalInt(ctx *Ctx, what string) (int64, error) {
+	v, err := e.Eval(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if v.tp != TInt {
+		return 0, ctx.errorf(e.pos, "%s is %s, want int", what, Types[v.tp])
+	}
+	return v.i, nil
+}
+
+// evalBool evaluates e, described by what, which must be a bool.
+func (e *Expr) evalBool(ctx *Ctx, what string) (bool, error) {
+	v, err := e.Eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	if v.tp != TBool {
+		return false, ctx.errorf(e.pos, "%s is %s, want bool", what, Types[v.tp])
+	}
+	return v.Bool(), nil
+}
+
+func (e *Expr) evalUnary(ctx *Ctx, r Value) (Value, error) {
+	switch {
+	case r.tp == TInt && e.op == fxlex.TokMinus:
+		return IntVal(-r.i), nil
+	case r.tp == TInt && e.op == fxlex.TokPlus:
+		return r, nil
+	case r.tp == TInt && e.op == '~':
+		return IntVal(^r.i), nil
+	case r.tp == TBool && e.op == fxlex.TokNeg:
+		return BoolVal(!r.Bool()), nil
+	case r.tp == TCoord && e.op == fxlex.TokMinus:
+		return CoordVal(Coord{-r.c.X, -r.c.Y}), nil
+	case r.tp == TCoord && e.op == fxlex.TokPlus:
+		return r, nil
+	}
+
+	return Value{}, ctx.errorf(e.pos, "invalid operand of %s: %s", opString(e), Types[r.tp])
+}
+
+func (e *Expr) evalBinary(ctx *Ctx, l, r Value) (Value, error) {
+	switch {
+	case l.tp == TInt && r.tp == TInt:
+		return e.evalInts(ctx, l.i, r.i)
+	case l.tp == TBool && r.tp == TBool:
+		lB, rB := l.Bool(), r.Bool()
+		switch e.op {
+		case fxlex.TokOr:
+			return BoolVal(lB || rB), nil
+		case fxlex.TokAnd:
+			return BoolVal(lB && rB), nil
+		case fxlex.TokXor:
+			return BoolVal(lB != rB), nil
+		case OpEq:
+			return BoolVal(lB == rB), nil
+		case OpNeq:
+			return BoolVal(lB != rB), nil
+		}
+	case l.tp == TCoord && r.tp == TCoord:
+		switch e.op {
+		case fxlex.TokPlus:
+			return CoordVal(Coord{l.c.X + r.c.X, l.c.Y + r.c.Y}), nil
+		case fxlex.TokMinus:
+			return CoordVal(Coord{l.c.X - r.c.X, l.c.Y - r.c.Y}), nil
+		case OpEq:
+			return BoolVal(l.c == r.c), nil
+		case OpNeq:
+			return BoolVal(l.c != r.c), nil
+		}
+	case l.tp == TCoord && r.tp == TInt:
+		switch e.op {
+		case fxlex.TokTimes:
+			return CoordVal(Coord{l.c.X * r.i, l.c.Y * r.i}), nil
+		case fxlex.TokDivide:
+			if r.i == 0 {
+				return Value{}, ctx.errorf(e.pos, "division by zero")
+			}
+			return CoordVal(Coord{l.c.X / r.i, l.c.Y / r.i}), nil
+		}
+	case l.tp == TInt && r.tp == TCoord:
+		if e.op == fxlex.TokTimes {
+			return CoordVal(Coord{l.i * r.c.X, l.i * r.c.Y}), nil
+		}
+	}
+
+	return Value{}, ctx.errorf(e.pos, "invalid operands of %s: %s and %s", opString(e), Types[l.tp], Types[r.tp])
+}
+
+func (e *Expr) evalInts(ctx *Ctx, lV, rV int64) (Value, error) {
+	switch e.op {
 	case fxlex.TokMinus:
-		return lV - rV
+		return IntVal(lV - rV), nil
 	case fxlex.TokPlus:
-		return lV + rV
+		return IntVal(lV + rV), nil
 	case fxlex.TokTimes:
-		return lV * rV
+		return IntVal(lV * rV), nil
 	case fxlex.TokDivide:
-		return lV / rV
+		if rV == 0 {
+			return Value{}, ctx.errorf(e.pos, "division by zero")
+		}
+		return IntVal(lV / rV), nil
 	case fxlex.TokRem:
-		return int64(math.Mod(float64(lV), float64(rV)))
+		if rV == 0 {
+			return Value{}, ctx.errorf(e.pos, "division by zero")
+		}
+		return IntVal(lV % rV), nil
 	case fxlex.TokPow:
-		return int64(math.Pow(float64(lV), float64(rV)))
-	case fxlex.TokGT:
-		if lV > rV {
-			return 1.0
+		if rV < 0 {
+			return Value{}, ctx.errorf(e.pos, "negative exponent %d", rV)
 		}
-		return 0.0
-	case fxlex.TokLT:
-		if lV < rV {
-			return 1.0
+		v, ok := ipow(lV, rV)
+		if !ok {
+			return Value{}, ctx.errorf(e.pos, "integer overflow in %d ** %d", lV, rV)
 		}
-		return 0.0
+		return IntVal(v), nil
+	case fxlex.TokGT:
+		return BoolVal(lV > rV), nil
+	case fxlex.TokLT:
+		return BoolVal(lV < rV), nil
 	case fxlex.TokGTE:
-		if lV >= rV {
-			return 1.0
-		}
-		return 0.0
+		return BoolVal(lV >= rV), nil
 	case fxlex.TokLTE:
-		if lV <= rV {
-			return 1.0
-		}
-		return 0.0
-	case fxlex.TokOr:
-		if (lV != 0) || (rV != 0) {
-			return 1.0
-		}
-		return 0.0
-	case fxlex.TokAnd:
-		if (lV != 0) && (rV != 0) {
-			return 1.0
-		}
-		return 0.0
-	case fxlex.TokNeg:
-		if !(rV != 0) {
-			return 1.0
-		}
-		return 0.0
-	case fxlex.TokXor:
-		if (lV != 0) != (rV != 0) {
-			return 1.0
-		}
-		return 0.0
-	case fxlex.TokIntLit:
-		return tok.GetValue()
-	case fxlex.TokBoolLit:
-		return tok.GetValue()
-	case fxlex.TokID:
-		sym := envs.GetSym(tok.GetLexeme())
-		if sym == nil {
-			panic(fmt.Sprintf("symbol %s does not exist", tok.GetLexeme()))
+		return BoolVal(lV <= rV), nil
+	case OpEq:
+		return BoolVal(lV == rV), nil
+	case OpNeq:
+		return BoolVal(lV != rV), nil
+	case OpBitAnd:
+		return IntVal(lV & rV), nil
+	case OpBitOr:
+		return IntVal(lV | rV), nil
+	case OpBitXor:
+		return IntVal(lV ^ rV), nil
+	case OpShl, OpShr:
+		if rV < 0 {
+			return Value{}, ctx.errorf(e.pos, "negative shift count %d", rV)
 		}
+		if e.op == OpShl {
+			return IntVal(lV << uint64(rV)), nil
+		}
+		return IntVal(lV >> uint64(rV)), nil
+	}
 
-		return sym.Content().(int64)
-	default:
-		panic("Bad subtree")
+	return Value{}, ctx.errorf(e.pos, "invalid operands of %s: int and int", opString(e))
+}
+
+// ipow returns b ** n, for n >= 0, and whether it fits in an int64.
+func ipow(b, n int64) (int64, bool) {
+	r := int64(1)
+	ok := true
+	for n > 0 {
+		if n&1 == 1 {
+			if r, ok = imul(r, b); !ok {
+				return 0, false
+			}
+		}
+		n >>= 1
+		if n > 0 {
+			if b, ok = imul(b, b); !ok {
+				return 0, false
+			}
+		}
+	}
+
+	return r, true
+}
+
+// imul returns a * b and whether it fits in an int64.
+func imul(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
 	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+
+	return c, true
 }
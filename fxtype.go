@@ -32,3 +32,27 @@ func (tp *Type) String() string {
 	}
 	return typeNames[tp.id]
 }
+
+// Coord is a point, the value of the expressions of type Coord.
+type Coord struct {
+	X int64
+	Y int64
+}
+
+// Color is a color given to a builtin, packed in an int as 0xTTRRGGBB.
+// T is the transparency, from 0 (opaque) to 100 (invisible).
+type Color struct {
+	T uint8
+	R uint8
+	G uint8
+	B uint8
+}
+
+// Opacity returns how opaque c is, from 0 to 1. Transparencies over
+// 100 are taken as 100.
+func (c Color) Opacity() float64 {
+	if c.T >= 100 {
+		return 0
+	}
+	return float64(100-c.T) / 100
+}
@@ -0,0 +1,67 @@
+package fxparse
+
+import "fmt"
+
+// Value is the result of evaluating an expression, and the content of
+// a variable, at runtime. It is tagged with its type, one of TInt,
+// TBool or TCoord. Colors have no tag of their own: they are ints, as
+// in the parameters of the builtins, so that programs can compute them
+// with arithmetic and bitwise operators. Color decodes one.
+type Value struct {
+	tp int
+	i  int64
+	c  Coord
+}
+
+func IntVal(i int64) Value {
+	return Value{tp: TInt, i: i}
+}
+
+func BoolVal(b bool) Value {
+	if b {
+		return Value{tp: TBool, i: 1}
+	}
+	return Value{tp: TBool}
+}
+
+func CoordVal(c Coord) Value {
+	return Value{tp: TCoord, c: c}
+}
+
+// Type returns the type of v, TUndef for the zero Value.
+func (v Value) Type() int {
+	return v.tp
+}
+
+func (v Value) Int() int64 {
+	return v.i
+}
+
+func (v Value) Bool() bool {
+	return v.i != 0
+}
+
+func (v Value) Coord() Coord {
+	return v.c
+}
+
+// Color decodes v, an int, as a color.
+func (v Value) Color() Color {
+	return DecodeColor(v.i)
+}
+
+func (v Value) String() string {
+	switch v.tp {
+	case TInt:
+		return fmt.Sprintf("%d", v.i)
+	case TBool:
+		if v.Bool() {
+			return "True"
+		}
+		return "False"
+	case TCoord:
+		return fmt.Sprintf("[%d, %d]", v.c.X, v.c.Y)
+	}
+
+	return nullString
+}
@@ -0,0 +1,335 @@
+package fxparse
+
+import (
+	"fxlex"
+	"fxsym"
+)
+
+// Node is a node of the program tree.
+type Node interface {
+	Pos() Pos
+	End() Pos
+}
+
+func (p *Prog) Funcs() []*Func {
+	funcs := make([]*Func, 0, len(p.funcs))
+	for _, fSym := range p.funcs {
+		funcs = append(funcs, fSym.Content().(*Func))
+	}
+
+	return funcs
+}
+
+func (p *Prog) EndComments() []*Comment {
+	return p.endComments
+}
+
+func (f *Func) Name() string {
+	return f.head.id
+}
+
+func (f *Func) Head() *Head {
+	return f.head
+}
+
+func (f *Func) Body() *Body {
+	return f.body
+}
+
+func (f *Func) Doc() []*Comment {
+	return f.doc
+}
+
+func (f *Func) LineComment() *Comment {
+	return f.comment
+}
+
+func (h *Head) Name() string {
+	return h.id
+}
+
+func (h *Head) Params() []*fxsym.Sym {
+	return h.params
+}
+
+// Result returns the type of the value the macro returns, TUndef
+// if it returns none.
+func (h *Head) Result() int {
+	return h.result
+}
+
+func (b *Body) Stms() []*Statement {
+	return b.stms
+}
+
+func (b *Body) EndComments() []*Comment {
+	return b.endComments
+}
+
+// SetStms replaces the statements of b.
+func (b *Body) SetStms(stms []*Statement) {
+	b.stms = stms
+}
+
+func (stm *Statement) Call() *Call {
+	return stm.call
+}
+
+func (stm *Statement) Iter() *Iter {
+	return stm.iter
+}
+
+func (stm *Statement) Body() *Body {
+	return stm.body
+}
+
+func (stm *Statement) Decl() *fxsym.Sym {
+	return stm.decl
+}
+
+func (stm *Statement) Asign() *Asign {
+	return stm.asign
+}
+
+func (stm *Statement) NodeIf() *NodeIf {
+	return stm.nodeIf
+}
+
+func (stm *Statement) While() *While {
+	return stm.while
+}
+
+func (stm *Statement) Branch() *Branch {
+	return stm.branch
+}
+
+func (stm *Statement) Return() *Return {
+	return stm.ret
+}
+
+func (stm *Statement) Doc() []*Comment {
+	return stm.doc
+}
+
+func (stm *Statement) LineComment() *Comment {
+	return stm.comment
+}
+
+func (c *Call) Func() *fxsym.Sym {
+	return c.f
+}
+
+func (c *Call) Args() []*Expr {
+	return c.args
+}
+
+func (iter *Iter) VarControl() *fxsym.Sym {
+	return iter.varControl
+}
+
+// Range returns the expressions for the start, end and step of iter.
+func (iter *Iter) Range() (start, end, step *Expr) {
+	return iter.start, iter.end, iter.step
+}
+
+// Incl returns <= or >= for an iter including its end, empty if not.
+func (iter *Iter) Incl() string {
+	return iter.incl
+}
+
+func (iter *Iter) Body() *Body {
+	return iter.body
+}
+
+func (asign *Asign) Sym() *fxsym.Sym {
+	return asign.sym
+}
+
+func (asign *Asign) Value() *Expr {
+	return asign.value
+}
+
+func (while *While) Cond() *Expr {
+	return while.cond
+}
+
+func (while *While) Body() *Body {
+	return while.body
+}
+
+// Key returns the keyword of branch, break or continue.
+func (branch *Branch) Key() string {
+	return branch.key
+}
+
+// Value returns the expression returned, nil if there is none.
+func (ret *Return) Value() *Expr {
+	return ret.value
+}
+
+func (nodeIf *NodeIf) Cond() *Expr {
+	return nodeIf.cond
+}
+
+func (nodeIf *NodeIf) Body() *Body {
+	return nodeIf.body
+}
+
+// ElseIf returns the if chained after else, nil if there is none.
+func (nodeIf *NodeIf) ElseIf() *NodeIf {
+	return nodeIf.elseIf
+}
+
+func (nodeIf *NodeIf) BodyElse() *Body {
+	return nodeIf.bodyElse
+}
+
+// Doc returns the comments between else and the body before it,
+// for an if chained after else.
+func (nodeIf *NodeIf) Doc() []*Comment {
+	return nodeIf.doc
+}
+
+// LineComment returns the comment ending the line of else if, for an
+// if chained after else.
+func (nodeIf *NodeIf) LineComment() *Comment {
+	return nodeIf.comment
+}
+
+// ElseDoc returns the comments between the final else and the body
+// before it.
+func (nodeIf *NodeIf) ElseDoc() []*Comment {
+	return nodeIf.elseDoc
+}
+
+// ElseLineComment returns the comment ending the line of the final else.
+func (nodeIf *NodeIf) ElseLineComment() *Comment {
+	return nodeIf.elseComment
+}
+
+func (e *Expr) Tok() fxlex.Token {
+	return e.tok
+}
+
+// Op returns the operator of e, the type of its token or, for
+// operators made of two tokens, one of OpEq, OpNeq, OpShl or OpShr.
+// Once checked, the int forms of &, | and ^ are OpBitAnd, OpBitOr and
+// OpBitXor.
+func (e *Expr) Op() int {
+	return e.op
+}
+
+// Call returns the call of a macro made by a ( expression.
+func (e *Expr) Call() *Call {
+	return e.call
+}
+
+// Sel returns the component selected by a . expression, x or y.
+func (e *Expr) Sel() string {
+	return e.sel
+}
+
+// A Visitor's Visit method is called by Walk for each node. If the
+// result w is not nil, Walk visits each of the children of node with w,
+// followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order,
+// following the order of the source.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Prog:
+		for _, f := range n.Funcs() {
+			Walk(v, f)
+		}
+	case *Func:
+		Walk(v, n.head)
+		if n.body != nil {
+			Walk(v, n.body)
+		}
+	case *Head:
+	case *Body:
+		for _, stm := range n.stms {
+			Walk(v, stm)
+		}
+	case *Statement:
+		switch {
+		case n.call != nil:
+			Walk(v, n.call)
+		case n.iter != nil:
+			Walk(v, n.iter)
+		case n.body != nil:
+			Walk(v, n.body)
+		case n.asign != nil:
+			Walk(v, n.asign)
+		case n.nodeIf != nil:
+			Walk(v, n.nodeIf)
+		case n.while != nil:
+			Walk(v, n.while)
+		case n.branch != nil:
+			Walk(v, n.branch)
+		case n.ret != nil:
+			Walk(v, n.ret)
+		}
+	case *Call:
+		walkExprs(v, n.args...)
+	case *Iter:
+		walkExprs(v, n.start, n.end, n.step)
+		Walk(v, n.body)
+	case *Asign:
+		walkExprs(v, n.value)
+	case *NodeIf:
+		walkExprs(v, n.cond)
+		Walk(v, n.body)
+		if n.elseIf != nil {
+			Walk(v, n.elseIf)
+		}
+		if n.bodyElse != nil {
+			Walk(v, n.bodyElse)
+		}
+	case *While:
+		walkExprs(v, n.cond)
+		Walk(v, n.body)
+	case *Branch:
+	case *Return:
+		walkExprs(v, n.value)
+	case *Expr:
+		if n.call != nil {
+			Walk(v, n.call)
+			break
+		}
+		walkExprs(v, n.ELeft, n.ERight)
+	}
+
+	v.Visit(nil)
+}
+
+func walkExprs(v Visitor, exprs ...*Expr) {
+	for _, e := range exprs {
+		if e != nil {
+			Walk(v, e)
+		}
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order,
+// calling f(node) for each node and then f(nil) once its children
+// are done. If f returns false, the children of node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}